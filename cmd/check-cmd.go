@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/guettli/tff/pkg/tff"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	checkCmd := &cobra.Command{
+		Use:   "check combos.yaml",
+		Short: "Check combos.yaml for duplicates, unreachable and conflicting definitions. Exits with a non-zero exit code, if a problem was found.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Problems in combos.yaml are no usage errors. Execute prints the error.
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			return tff.CheckMain(args[0])
+		},
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+	}
+	rootCmd.AddCommand(checkCmd)
+}
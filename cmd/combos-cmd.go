@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"os"
 
 	"github.com/guettli/tff/pkg/tff"
 	"github.com/spf13/cobra"
@@ -15,7 +17,13 @@ func init() {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			config.ConfigFile = args[0]
 			config.DevicePaths = args[1:]
-			return tff.CombosMain(cmd.Context(), config)
+			err := tff.CombosMain(cmd.Context(), config)
+			if errors.Is(err, tff.ErrEmergencyExit) {
+				// systemd does not restart the service on this exit code.
+				fmt.Println(err)
+				os.Exit(tff.EmergencyExitCode)
+			}
+			return err
 		},
 		Args: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/guettli/tff/pkg/tff"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var socket string
+	ctlCmd := &cobra.Command{
+		Use:   "ctl [--socket path] command [group]",
+		Short: "Control a running 'tff combos' via its control socket. Commands: " + strings.Join(tff.ControlCommands, ", "),
+		Long: `Control a running 'tff combos' via its control socket. Set 'controlSocket' in combos.yaml to enable the socket.
+
+Commands:
+  status          show the devices, the active layers and the buffer of each engine
+  pause           pass all keys through unmodified
+  resume          use the combos again
+  reload          load combos.yaml again
+  combos          list all combos
+  enable group    enable the combos with 'group: group'
+  disable group   disable the combos with 'group: group'`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tff.CtlMain(socket, args)
+		},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || len(args) > 2 {
+				return fmt.Errorf("Usage: tff ctl command [group]. Commands: %s", strings.Join(tff.ControlCommands, ", "))
+			}
+			return nil
+		},
+	}
+	ctlCmd.Flags().StringVarP(&socket, "socket", "s", tff.DefaultControlSocket, "Path of the control socket")
+	rootCmd.AddCommand(ctlCmd)
+}
@@ -6,18 +6,20 @@ import (
 )
 
 func init() {
+	layout := ""
 	combosCmd := &cobra.Command{
-		Use:   "print [device]",
+		Use:   "print [--layout name] [device]",
 		Short: "Conntect to one evdev device and print the events. Needs root permissions.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			device := ""
 			if len(args) > 0 {
 				device = args[0]
 			}
-			return tff.PrintMain(device)
+			return tff.PrintMain(device, layout)
 		},
 		Args:                  cobra.RangeArgs(0, 1),
 		DisableFlagsInUseLine: true,
 	}
+	combosCmd.Flags().StringVar(&layout, "layout", "", "Show the characters of the keycaps of this layout (us, de, fr)")
 	rootCmd.AddCommand(combosCmd)
 }
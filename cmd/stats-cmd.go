@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"github.com/guettli/tff/pkg/tff"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var file string
+	statsCmd := &cobra.Command{
+		Use:   "stats [--file stats.json]",
+		Short: "Print the usage statistics: combos, near misses and hard-to-reach keys which were pressed directly. Set 'statsFile' in combos.yaml to collect them.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tff.StatsMain(file)
+		},
+		Args:                  cobra.NoArgs,
+		DisableFlagsInUseLine: true,
+	}
+	statsCmd.Flags().StringVarP(&file, "file", "f", tff.DefaultStatsFile, "Path of the stats file")
+	rootCmd.AddCommand(statsCmd)
+}
@@ -0,0 +1,53 @@
+package tff
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// isBlocked returns true, if the key is in 'block' of combos.yaml, or in 'block' of the device.
+func (state *State) isBlocked(key KeyCode, device string) bool {
+	if slices.Contains(state.config.Block, key) {
+		return true
+	}
+	if device == "" {
+		return false
+	}
+	deviceConfig := state.config.deviceByName(device)
+	return deviceConfig != nil && slices.Contains(deviceConfig.Block, key)
+}
+
+// dropBlocked returns true, if the event is a blocked key. Blocked keys never reach the engine.
+// This is useful to force yourself to use a combo instead of the key.
+func (state *State) dropBlocked(ev *Event, device string) bool {
+	if ev.Type != evdev.EV_KEY || !state.isBlocked(ev.Code, device) {
+		return false
+	}
+	if !state.config.CountBlocked || ev.Value != DOWN {
+		fmt.Printf(" skipping (blocked): %s\n", ev.String())
+		return true
+	}
+	if state.blockedCounts == nil {
+		state.blockedCounts = make(map[KeyCode]int)
+	}
+	state.blockedCounts[ev.Code]++
+	fmt.Printf(" skipping (blocked): %s. Attempt %d\n", ev.String(), state.blockedCounts[ev.Code])
+	return true
+}
+
+// printBlockedCounts prints how often each blocked key was pressed.
+func (state *State) printBlockedCounts() {
+	if len(state.blockedCounts) == 0 {
+		return
+	}
+	keys := slices.Sorted(maps.Keys(state.blockedCounts))
+	s := make([]string, 0, len(keys))
+	for _, key := range keys {
+		s = append(s, fmt.Sprintf("%s: %d", keyToString(key), state.blockedCounts[key]))
+	}
+	fmt.Printf("Blocked keys: %s\n", strings.Join(s, ", "))
+}
@@ -0,0 +1,88 @@
+package tff
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Block(t *testing.T) {
+	AssertYamlStateStringInputOutput(t, "esc_ (20ms) esc= (20ms) esc/ (200ms) a_ (20ms) a/",
+		`
+		A-down
+		A-up
+		`, `
+block:
+  - esc
+  - backspace
+combos:
+  - keys: f j
+    outKeys: esc
+`)
+}
+
+func Test_Block_Device(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+countBlocked: true
+block:
+  - backspace
+devices:
+  - name: pedal
+    path: /dev/input/by-id/usb-pedal-event-kbd
+  - name: laptop
+    path: /dev/input/by-path/platform-i8042-serio-0-event-kbd
+    block:
+      - esc
+combos:
+  - keys: f j
+    outKeys: x
+`))
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	state := NewState(config, &ew)
+	input := "laptop:esc_ (20ms) laptop:esc/ (200ms) pedal:esc_ (20ms) pedal:esc/ (200ms) laptop:esc_ (20ms) laptop:esc/ (200ms) pedal:backspace_ (20ms) pedal:backspace/"
+	er := newDeviceSliceReader(t, input)
+	for {
+		ev, device, err := er.ReadOneWithDevice()
+		if err != nil {
+			break
+		}
+		if state.dropBlocked(ev, device) {
+			continue
+		}
+		require.NoError(t, manInTheMiddleInnerLoop(ev, &ew, state))
+	}
+	ew.requireEqual(t, `
+		ESC-down
+		ESC-up
+		`)
+	require.Equal(t, map[KeyCode]int{evdev.KEY_ESC: 2, evdev.KEY_BACKSPACE: 1}, state.blockedCounts)
+
+	// The same via manInTheMiddle.
+	ew = writeToSlice{}
+	err = manInTheMiddle(context.Background(), newDeviceSliceReader(t, input), &ew, config, VirtualClock{}, nil)
+	require.NoError(t, err)
+	ew.requireEqual(t, `
+		ESC-down
+		ESC-up
+		`)
+}
+
+func Test_Block_LoadYaml(t *testing.T) {
+	_, err := LoadYamlFromBytes([]byte("block:\n  - esc\n  - escape\n"))
+	require.ErrorContains(t, err, `invalid key in 'block'`)
+
+	_, err = LoadYamlFromBytes([]byte("block:\n  - esc\n  - esc\n"))
+	require.ErrorContains(t, err, `key "esc" is in 'block' twice.`)
+
+	// A config which only blocks keys is fine.
+	config, err := LoadYamlFromBytes([]byte("block:\n  - esc\ncombos:\n  - keys: esc j\n    outKeys: x\n"))
+	require.NoError(t, err)
+	require.False(t, config.isEmpty())
+	require.Equal(t, []Finding{{
+		Line:    4,
+		Message: `combo "KEY_ESC KEY_J" is unreachable: key "KEY_ESC" is in 'block'.`,
+	}}, config.Findings)
+}
@@ -0,0 +1,75 @@
+package tff
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// Mouse buttons (like btn_middle) can be used in combos. Buttons which are not used in a combo or
+// sequence get written immediately, so that normal clicks do not get delayed.
+
+const buttonPrefix = "btn_"
+
+func isButton(key KeyCode) bool {
+	return key >= evdev.BTN_MISC && key < evdev.KEY_OK
+}
+
+// buttonName removes the aliases of buttons. Example: "BTN_MOUSE/BTN_LEFT" gets "BTN_LEFT".
+// Other names are unchanged: the last alias of a key is not the name of the key, for example
+// "KEY_MUTE/KEY_MIN_INTERESTING".
+func buttonName(name string) string {
+	if !strings.HasPrefix(name, "BTN_") {
+		return name
+	}
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// buttons returns the buttons which are used in combos or sequences of the config.
+func (c *Config) buttons() map[KeyCode]bool {
+	buttons := make(map[KeyCode]bool)
+	add := func(keys ...KeyCode) {
+		for _, key := range keys {
+			if isButton(key) {
+				buttons[key] = true
+			}
+		}
+	}
+	for _, layer := range c.Layers {
+		for _, combo := range layer.Combos {
+			add(combo.Keys...)
+		}
+		for _, seq := range layer.Sequences {
+			add(seq.Keys...)
+		}
+	}
+	return buttons
+}
+
+// handleUnusedButton writes buttons which are not used in the config. Pending keys get written
+// first, so that the order stays the same.
+func (state *State) handleUnusedButton(ev Event) (bool, error) {
+	if !isButton(ev.Code) || state.buttonsOfConfig[ev.Code] {
+		return false, nil
+	}
+	return true, state.FlushBufferAndWriteEvent(ev, "Button")
+}
+
+// flushButtonBeforeMotion writes a button which is in the buffer, before the pointer moves.
+// Otherwise the movement would come before the click. Pointing sticks use the middle button
+// plus movement for scrolling.
+func (state *State) flushButtonBeforeMotion(ev *Event) error {
+	if ev.Type != evdev.EV_REL || len(state.downKeysWritten) > 0 {
+		return nil
+	}
+	if !slices.ContainsFunc(state.buf, func(bufEvent Event) bool {
+		return isButton(bufEvent.Code) && bufEvent.Value == DOWN
+	}) {
+		return nil
+	}
+	return state.FlushBuffer("Motion")
+}
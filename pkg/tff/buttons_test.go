@@ -0,0 +1,88 @@
+package tff
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+const buttonsYaml = `
+combos:
+  - keys: btn_middle j
+    outKeys: alt+left
+  - keys: f j
+    outKeys: x
+`
+
+func Test_Buttons_Combo(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(buttonsYaml))
+	require.NoError(t, err)
+	require.Equal(t, "BTN_MIDDLE KEY_J -> KEY_LEFTALT KEY_LEFT", config.BaseLayer().Combos[0].String())
+
+	for _, tt := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"combo",
+			"btn_middle_ (20ms) j_ (100ms) j/ (20ms) btn_middle/",
+			"LEFTALT-down\nLEFT-down\nLEFTALT-up\nLEFT-up",
+		},
+		{
+			"middle click",
+			"btn_middle_ (100ms) btn_middle/",
+			"BTN_MIDDLE-down\nBTN_MIDDLE-up",
+		},
+		{
+			// The left button is not used in a combo. It gets written immediately.
+			"unused button",
+			"f_ (20ms) btn_left_ (20ms) btn_left/ (100ms) f/",
+			"F-down\nBTN_LEFT-down\nBTN_LEFT-up\nF-up",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			er, err := NewReadFromSliceInputStateString(tt.input)
+			require.NoError(t, err)
+			ew := writeToSlice{}
+			require.NoError(t, manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil))
+			ew.requireEqual(t, tt.expected)
+		})
+	}
+}
+
+func Test_Buttons_Motion(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(buttonsYaml))
+	require.NoError(t, err)
+	events, err := stateStringToSlice("btn_middle_ (20ms) btn_middle/")
+	require.NoError(t, err)
+	// The pointing stick moves while the middle button is held.
+	events = slices.Insert(events, 1, Event{Time: events[0].Time, Type: evdev.EV_REL, Code: evdev.REL_Y, Value: 3})
+	ew := writeToSlice{}
+	require.NoError(t, manInTheMiddle(context.Background(), &readFromSlice{s: events}, &ew, config, VirtualClock{}, nil))
+	var actual []string
+	for _, ev := range ew.s {
+		if !eventToSkip(&ev) {
+			actual = append(actual, eventToString(&ev, nil))
+		}
+	}
+	require.Equal(t, []string{"btn_middle_", "y+3", "btn_middle/"}, actual)
+}
+
+func Test_wordToKeyCode_Button(t *testing.T) {
+	key, err := wordToKeyCode("btn_left")
+	require.NoError(t, err)
+	require.Equal(t, KeyCode(evdev.BTN_LEFT), key)
+	require.Equal(t, "BTN_LEFT", keyCodeName(key))
+	_, err = wordToKeyCode("btn_foo")
+	require.ErrorIs(t, err, UnknownKeyErr)
+}
+
+func Test_buttonName(t *testing.T) {
+	require.Equal(t, "BTN_LEFT", buttonName("BTN_MOUSE/BTN_LEFT"))
+	require.Equal(t, "KEY_MUTE/KEY_MIN_INTERESTING", buttonName("KEY_MUTE/KEY_MIN_INTERESTING"))
+	require.Equal(t, "MUTE/KEY_MIN_INTERESTING", keyToString(evdev.KEY_MUTE))
+}
@@ -0,0 +1,307 @@
+package tff
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Finding is a problem of combos.yaml which does not prevent loading the config. For example, a
+// combo which can never match. Errors (like a combo which is defined twice) get returned by
+// LoadYamlFromBytes instead.
+type Finding struct {
+	// Line in combos.yaml. Zero, if unknown.
+	Line    int
+	Message string
+}
+
+func (f Finding) String() string {
+	if f.Line == 0 {
+		return f.Message
+	}
+	return fmt.Sprintf("line %d: %s", f.Line, f.Message)
+}
+
+// CheckMain loads the config and prints the findings. It returns an error, if the config can not
+// be loaded, or if there are findings.
+func CheckMain(configFile string) error {
+	config, err := LoadYamlFile(configFile)
+	if err != nil {
+		return err
+	}
+	printFindings(configFile, config.Findings)
+	if len(config.Findings) > 0 {
+		return fmt.Errorf("%q: %d problems found.", configFile, len(config.Findings))
+	}
+	fmt.Printf("%q: no problems found.\n", configFile)
+	return nil
+}
+
+func printFindings(configFile string, findings []Finding) {
+	for _, f := range findings {
+		fmt.Printf("warning: %s: %s\n", configFile, f.String())
+	}
+}
+
+// checkConfig searches for definitions which are valid, but which very likely do not do what the
+// user expects. The findings are sorted by line.
+func checkConfig(config *Config) []Finding {
+	var findings []Finding
+	add := func(line int, format string, args ...any) {
+		findings = append(findings, Finding{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+	reachable := reachableLayers(config)
+	base := config.BaseLayer()
+	for _, layer := range config.Layers {
+		if !reachable[layer] {
+			add(layer.Line, "layer %q is unreachable: no key activates it.", layer.Name)
+			continue
+		}
+		for _, remap := range layer.Remaps {
+			if slices.Contains(config.Block, remap.Key) {
+				add(remap.Line, "remap of %q is unreachable: the key is in 'block'.", keyCodeName(remap.Key))
+			}
+		}
+		for _, combo := range layer.Combos {
+			if key, ok := blockedKey(combo.Keys, config.Block); ok {
+				add(combo.Line, "combo %q is unreachable: key %q is in 'block'.", combo.keysString(),
+					keyCodeName(key))
+				continue
+			}
+			if key, remapLayer := remappedKey(combo.Keys, layer, base); remapLayer != nil {
+				add(combo.Line, "combo %q is unreachable: key %q is remapped in layer %q (line %d).",
+					combo.keysString(), keyCodeName(key), remapLayer.Name, remapLayer.Remaps[key].Line)
+				continue
+			}
+		}
+		for i, a := range layer.Combos {
+			for _, b := range layer.Combos[i+1:] {
+				checkComboPair(a, b, add)
+			}
+		}
+		for _, seq := range layer.Sequences {
+			if key, ok := blockedKey(seq.Keys, config.Block); ok {
+				add(seq.Line, "sequence %q is unreachable: key %q is in 'block'.", seq.keysString(),
+					keyCodeName(key))
+				continue
+			}
+			if key, remapLayer := remappedKey(seq.Keys, layer, base); remapLayer != nil {
+				add(seq.Line, "sequence %q is unreachable: key %q is remapped in layer %q (line %d).",
+					seq.keysString(), keyCodeName(key), remapLayer.Name, remapLayer.Remaps[key].Line)
+				continue
+			}
+			if combo := comboWithKey(layer, seq.Keys[0]); combo != nil {
+				add(seq.Line, "sequence %q starts only if %q gets tapped: the key is part of combo %q (line %d).",
+					seq.keysString(), keyCodeName(seq.Keys[0]), combo.keysString(), combo.Line)
+			}
+			for _, other := range layer.Sequences {
+				if len(other.Keys) < len(seq.Keys) && slices.Equal(seq.Keys[:len(other.Keys)], other.Keys) {
+					add(seq.Line, "sequence %q is shadowed: sequence %q (line %d) finishes first.",
+						seq.keysString(), other.keysString(), other.Line)
+					break
+				}
+			}
+		}
+	}
+	checkFeedback(config, add)
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+	return findings
+}
+
+// reachableLayers returns the base layer, and all layers which can be activated by a key.
+func reachableLayers(config *Config) map[*Layer]bool {
+	reachable := map[*Layer]bool{config.BaseLayer(): true}
+	todo := []*Layer{config.BaseLayer()}
+	for len(todo) > 0 {
+		layer := todo[0]
+		todo = todo[1:]
+		for _, remap := range layer.Remaps {
+			name := ""
+			switch {
+			case remap.Action != nil:
+				name = remap.Action.Layer
+			case remap.TapHold != nil:
+				name = remap.TapHold.HoldLayer
+			}
+			other := config.LayerByName(name)
+			if other == nil || reachable[other] {
+				continue
+			}
+			reachable[other] = true
+			todo = append(todo, other)
+		}
+	}
+	return reachable
+}
+
+// blockedKey returns the first key which is in block.
+func blockedKey(keys, block []KeyCode) (KeyCode, bool) {
+	for _, key := range keys {
+		if slices.Contains(block, key) {
+			return key, true
+		}
+	}
+	return 0, false
+}
+
+// remappedKey returns the first key which is remapped in the layer or in the base layer.
+// Remapped keys do not get passed to combos and sequences. The base layer is always active, so
+// its remaps apply to all layers.
+func remappedKey(keys []KeyCode, layer, base *Layer) (KeyCode, *Layer) {
+	for _, key := range keys {
+		for _, l := range []*Layer{layer, base} {
+			if _, ok := l.Remaps[key]; ok {
+				return key, l
+			}
+		}
+	}
+	return 0, nil
+}
+
+// comboWithKey returns the first combo of the layer which contains the key. A leader which is
+// part of a combo gets passed to the combos. The sequence starts, if the leader gets tapped.
+func comboWithKey(layer *Layer, key KeyCode) *Combo {
+	for _, combo := range layer.Combos {
+		if slices.Contains(combo.Keys, key) {
+			return combo
+		}
+	}
+	return nil
+}
+
+// checkComboPair compares two combos of the same layer. a is defined before b.
+func checkComboPair(a, b *Combo, add func(line int, format string, args ...any)) {
+	if !keyDevicesOverlap(a, b) {
+		return
+	}
+	if combosOverlap(a, b) {
+		// Combos with equal modifiers get rejected by the loader. If one combo has no modifiers,
+		// the combo with modifiers is preferred.
+		if len(a.Modifiers) > 0 && len(b.Modifiers) > 0 &&
+			(modifiersImply(a.Modifiers, b.Modifiers) || modifiersImply(b.Modifiers, a.Modifiers)) {
+			add(b.Line, "combos %q (line %d) and %q can match at the same time. Then both get written.",
+				a.String(), a.Line, b.String())
+		}
+		return
+	}
+	if !modifiersEqual(a.Modifiers, b.Modifiers) {
+		return
+	}
+	short, long := a, b
+	if len(short.Keys) > len(long.Keys) {
+		short, long = b, a
+	}
+	if len(short.Keys) == len(long.Keys) || !comboIsPrefix(short, long) {
+		return
+	}
+	add(long.Line, "combo %q starts like combo %q (line %d): %q waits for minAge, "+
+		"and %q matches only if its remaining keys get pressed within minAge.",
+		long.keysString(), short.keysString(), short.Line, short.keysString(), long.keysString())
+}
+
+// modifiersImply returns true, if the modifiers of a are held, whenever the modifiers of b are
+// held. Each entry of a must contain all keys of an entry of b.
+func modifiersImply(a, b [][]KeyCode) bool {
+	for _, aKeys := range a {
+		if !slices.ContainsFunc(b, func(bKeys []KeyCode) bool {
+			for _, key := range bKeys {
+				if !slices.Contains(aKeys, key) {
+					return false
+				}
+			}
+			return true
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// comboIsPrefix returns true, if there is an order of pressing the keys of short, which is the
+// start of an order of pressing the keys of long.
+func comboIsPrefix(short, long *Combo) bool {
+	used := make([]bool, len(short.Keys))
+	var fill func(pos int) bool
+	fill = func(pos int) bool {
+		if pos == len(short.Keys) {
+			return true
+		}
+		for i, key := range short.Keys {
+			if used[i] || !short.keyAllowedAt(pos, key) || !long.keyAllowedAt(pos, key) {
+				continue
+			}
+			used[i] = true
+			if fill(pos + 1) {
+				return true
+			}
+			used[i] = false
+		}
+		return false
+	}
+	return fill(0)
+}
+
+// checkFeedback finds combos and sequences which write a key which is used in the keys of a
+// combo or sequence. The output of tff does not get evaluated again, so this does not trigger
+// the other definition.
+func checkFeedback(config *Config, add func(line int, format string, args ...any)) {
+	type definition struct {
+		combo *Combo
+		kind  string
+	}
+	users := make(map[KeyCode]definition)
+	var producers []definition
+	for _, layer := range config.Layers {
+		for _, combo := range layer.Combos {
+			for _, key := range combo.Keys {
+				if _, ok := users[key]; !ok {
+					users[key] = definition{combo, "combo"}
+				}
+			}
+			producers = append(producers, definition{combo, "combo"})
+		}
+		for _, seq := range layer.Sequences {
+			for _, key := range seq.Keys {
+				if _, ok := users[key]; !ok {
+					users[key] = definition{&seq.Combo, "sequence"}
+				}
+			}
+			producers = append(producers, definition{&seq.Combo, "sequence"})
+		}
+	}
+	for _, p := range producers {
+		if p.combo.Text != "" {
+			// Typing a text is not expected to trigger a combo.
+			continue
+		}
+		for _, key := range p.combo.writtenKeys() {
+			u, ok := users[key]
+			if !ok || u.combo == p.combo {
+				continue
+			}
+			add(p.combo.Line, "%s %q writes %q, which is used in %s %q (line %d). "+
+				"The output does not get evaluated again, so this does not trigger the %s.",
+				p.kind, p.combo.keysString(), keyCodeName(key), u.kind, u.combo.keysString(), u.combo.Line,
+				u.kind)
+			break
+		}
+	}
+}
+
+// writtenKeys returns all keys which get written by the combo.
+func (c *Combo) writtenKeys() []KeyCode {
+	if c.Output == nil {
+		return c.OutKeys
+	}
+	var keys []KeyCode
+	for _, step := range c.Output {
+		for _, key := range step.Keys {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,142 @@
+package tff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		expected []string
+	}{
+		{
+			"no findings",
+			`remaps:
+  - key: capslock
+    action: layer-hold nav
+combos:
+  - keys: f j
+    outKeys: x
+  - keys: f j
+    modifiers: shift
+    outKeys: y
+sequences:
+  - keys: rightalt e
+    outKeys: esc
+layers:
+  - name: nav
+    combos:
+      - keys: f j
+        outKeys: z
+`,
+			nil,
+		},
+		{
+			"unreachable layer",
+			`layers:
+  - name: nav
+    combos:
+      - keys: f j
+        outKeys: z
+`,
+			[]string{`line 2: layer "nav" is unreachable: no key activates it.`},
+		},
+		{
+			"remapped key",
+			`remaps:
+  - key: f
+    outKey: x
+combos:
+  - keys: f j
+    outKeys: y
+`,
+			[]string{`line 5: combo "KEY_F KEY_J" is unreachable: key "KEY_F" is remapped in layer "base" (line 2).`},
+		},
+		{
+			"leader is part of a combo",
+			`combos:
+  - keys: capslock n
+    outKeys: down
+sequences:
+  - keys: capslock g h
+    outKeys: home
+`,
+			[]string{`line 5: sequence "KEY_CAPSLOCK KEY_G KEY_H" starts only if "KEY_CAPSLOCK" gets tapped: the key is part of combo "KEY_CAPSLOCK KEY_N" (line 2).`},
+		},
+		{
+			"sequence shadowed",
+			`sequences:
+  - keys: rightalt e s
+    outKeys: x
+  - keys: rightalt e
+    outKeys: esc
+`,
+			[]string{`line 2: sequence "KEY_RIGHTALT KEY_E KEY_S" is shadowed: sequence "KEY_RIGHTALT KEY_E" (line 4) finishes first.`},
+		},
+		{
+			"prefix",
+			`combos:
+  - keys: f {j k}
+    outKeys: x
+  - keys: f k
+    outKeys: y
+  - keys: f j
+    outKeys: z
+`,
+			[]string{`line 2: combo "KEY_F {KEY_J KEY_K}" starts like combo "KEY_F KEY_K" (line 4): "KEY_F KEY_K" waits for minAge, and "KEY_F {KEY_J KEY_K}" matches only if its remaining keys get pressed within minAge.`,
+				`line 2: combo "KEY_F {KEY_J KEY_K}" starts like combo "KEY_F KEY_J" (line 6): "KEY_F KEY_J" waits for minAge, and "KEY_F {KEY_J KEY_K}" matches only if its remaining keys get pressed within minAge.`},
+		},
+		{
+			"modifiers",
+			`combos:
+  - keys: f j
+    modifiers: shift
+    outKeys: x
+  - keys: f j
+    modifiers: leftshift
+    outKeys: y
+  - keys: f j
+    modifiers: ctrl
+    outKeys: z
+`,
+			[]string{`line 5: combos "shift+KEY_F KEY_J -> KEY_X" (line 2) and "leftshift+KEY_F KEY_J -> KEY_Y" can match at the same time. Then both get written.`},
+		},
+		{
+			"feedback",
+			`combos:
+  - keys: f j
+    outKeys: x
+  - keys: x c
+    outKeys: y
+`,
+			[]string{`line 2: combo "KEY_F KEY_J" writes "KEY_X", which is used in combo "KEY_X KEY_C" (line 4). The output does not get evaluated again, so this does not trigger the combo.`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadYamlFromBytes([]byte(tt.yaml))
+			require.NoError(t, err)
+			var actual []string
+			for _, f := range config.Findings {
+				actual = append(actual, f.String())
+			}
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_CheckMain(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.yaml")
+	require.NoError(t, os.WriteFile(good, []byte("combos:\n  - keys: f j\n    outKeys: x\n"), 0o600))
+	require.NoError(t, CheckMain(good))
+
+	bad := filepath.Join(dir, "bad.yaml")
+	require.NoError(t, os.WriteFile(bad, []byte("layers:\n  - name: nav\n"), 0o600))
+	require.ErrorContains(t, CheckMain(bad), "1 problems found.")
+}
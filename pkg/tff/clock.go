@@ -0,0 +1,104 @@
+package tff
+
+import (
+	"syscall"
+	"time"
+)
+
+// Clock is the time source of the engine. The engine keeps deadlines for its timers. Before an
+// event gets handled, all timers with a deadline before the time of the event get fired. This
+// way the timers get fired in the correct order, measured in event time.
+// Additionally, the Clock fires the next deadline while no events arrive.
+type Clock interface {
+	// Timer returns a channel which receives a value, when the deadline is reached.
+	// A nil channel means: the deadline gets fired by the next event only.
+	Timer(deadline time.Time) <-chan time.Time
+}
+
+// RealClock gets used for live mode.
+type RealClock struct{}
+
+var _ Clock = RealClock{}
+
+func (RealClock) Timer(deadline time.Time) <-chan time.Time {
+	if deadline.Equal(maxTime) {
+		return nil
+	}
+	return time.After(time.Until(deadline))
+}
+
+// VirtualClock gets used for tests and for replaying logs. The time is defined by the events
+// only. Nothing sleeps, and the result does not depend on the speed of the machine.
+type VirtualClock struct{}
+
+var _ Clock = VirtualClock{}
+
+func (VirtualClock) Timer(deadline time.Time) <-chan time.Time {
+	return nil
+}
+
+// startTimer sets the deadline of the eval timer. The duration starts at the time of the event.
+func (state *State) startTimer(evTime syscall.Timeval, d time.Duration) {
+	state.evalDeadline = syscallTimevalToTime(evTime).Add(d)
+}
+
+func (state *State) startRepeatTimer(t time.Time, d time.Duration) {
+	state.repeatDeadline = t.Add(d)
+}
+
+func (state *State) stopRepeatTimer() {
+	state.repeatDeadline = maxTime
+}
+
+// nextDeadline returns maxTime, if no timer is active.
+func (state *State) nextDeadline() time.Time {
+	next := state.evalDeadline
+	if state.repeatDeadline.Before(next) {
+		next = state.repeatDeadline
+	}
+	if state.emergencyDeadline.Before(next) {
+		next = state.emergencyDeadline
+	}
+	if state.mouseDeadline.Before(next) {
+		next = state.mouseDeadline
+	}
+	if state.outputDeadline.Before(next) {
+		next = state.outputDeadline
+	}
+	if state.trainingDeadline.Before(next) {
+		next = state.trainingDeadline
+	}
+	return next
+}
+
+// fireTimers fires the timers which have a deadline before until. The timers get fired in the
+// order of their deadline. The deadline is used as the current time.
+func (state *State) fireTimers(until time.Time) error {
+	for {
+		next := state.nextDeadline()
+		if !next.Before(until) {
+			return nil
+		}
+		var err error
+		if next.Equal(state.emergencyDeadline) {
+			return state.emergencyExit(next)
+		}
+		switch {
+		case next.Equal(state.evalDeadline):
+			state.evalDeadline = maxTime
+			err = state.Eval(timeToSyscallTimeval(next), "timer")
+		case next.Equal(state.mouseDeadline):
+			err = state.afterMouseTimer(next)
+		case next.Equal(state.outputDeadline):
+			err = state.afterOutputTimer(next)
+		case next.Equal(state.trainingDeadline):
+			err = state.afterTrainingTimer(next)
+		default:
+			state.repeatDeadline = maxTime
+			err = state.afterRepeatTimer(next)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
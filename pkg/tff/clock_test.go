@@ -0,0 +1,87 @@
+package tff
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_VirtualClock_TimersFireInOrderOfDeadline(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+repeat:
+  delay: 100ms
+  interval: 100ms
+combos:
+  - keys: f j
+    outKeys: x
+`))
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	state := NewState(config, &ew)
+	start := time.Unix(1712500000, 0)
+	state.startTimer(timeToSyscallTimeval(start), 150*time.Millisecond)
+	state.repeatingKey = evdev.KEY_F
+	state.startRepeatTimer(start, 100*time.Millisecond)
+	require.Equal(t, start.Add(100*time.Millisecond), state.nextDeadline())
+
+	// The repeat timer fires first. Nothing gets repeated, but it gets started again.
+	require.NoError(t, state.fireTimers(start.Add(120*time.Millisecond)))
+	require.Equal(t, start.Add(150*time.Millisecond), state.nextDeadline())
+
+	require.NoError(t, state.fireTimers(start.Add(150*time.Millisecond)))
+	require.Equal(t, start.Add(150*time.Millisecond), state.nextDeadline(), "deadline equal to until must not fire")
+
+	require.NoError(t, state.fireTimers(start.Add(250*time.Millisecond)))
+	require.Equal(t, start.Add(300*time.Millisecond), state.nextDeadline())
+}
+
+// chanReader returns the events of the channel. It blocks, if the channel is empty.
+type chanReader struct {
+	ch chan *Event
+}
+
+func (r *chanReader) ReadOne() (*Event, error) {
+	return <-r.ch, nil
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	writeToSlice
+}
+
+func (w *syncWriter) WriteOne(ev *Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeToSlice.WriteOne(ev)
+}
+
+func (w *syncWriter) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.s)
+}
+
+func Test_RealClock_TimerFiresWithoutEvents(t *testing.T) {
+	config := NewConfigFromCombos([]*Combo{
+		{Keys: []KeyCode{evdev.KEY_F, evdev.KEY_J}, OutKeys: []KeyCode{evdev.KEY_X}},
+	})
+	er := &chanReader{ch: make(chan *Event, 2)}
+	ew := &syncWriter{}
+	now := time.Now()
+	er.ch <- &Event{Time: timeToSyscallTimeval(now), Type: evdev.EV_KEY, Code: evdev.KEY_F, Value: DOWN}
+	er.ch <- &Event{Time: timeToSyscallTimeval(now.Add(10 * time.Millisecond)), Type: evdev.EV_KEY, Code: evdev.KEY_J, Value: DOWN}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- manInTheMiddle(ctx, er, ew, config, RealClock{}, nil)
+	}()
+	require.Eventually(t, func() bool { return ew.len() > 0 }, 2*time.Second, 10*time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	ew.requireEqual(t, `X-down`)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/holoplot/go-evdev"
 )
@@ -24,7 +25,7 @@ type device struct {
 	// sourceDev is the device we read from
 	sourceDev *evdev.InputDevice
 
-	// outDev is the device we write to. Created via CloneDevice
+	// outDev is the device we write to. Created via createOutputDevice
 	outDev *evdev.InputDevice
 }
 
@@ -38,7 +39,8 @@ func (d *device) Open() error {
 	if err != nil {
 		return err
 	}
-	outDev, err := evdev.CloneDevice(fmt.Sprintf("tff-clone-%d-%d", os.Getpid(), d.id), sourceDev)
+	outDev, err := createOutputDevice(fmt.Sprintf("tff-clone-%d-%d", os.Getpid(), d.id),
+		[]*evdev.InputDevice{sourceDev})
 	if err != nil {
 		return errors.Join(sourceDev.Close(), err)
 	}
@@ -47,7 +49,41 @@ func (d *device) Open() error {
 	return nil
 }
 
+// ungrab gives the source device back to the system, after the emergency chord was used.
+func (d *device) ungrab() error {
+	if d.sourceDev == nil {
+		return nil
+	}
+	return errors.Join(d.sourceDev.Ungrab(), d.sourceDev.Close(), d.outDev.Close())
+}
+
 func CombosMain(ctx context.Context, cmdconfig CombosCmdConfig) error {
+	config, err := LoadYamlFile(cmdconfig.ConfigFile)
+	if err != nil {
+		return err
+	}
+	printFindings(cmdconfig.ConfigFile, config.Findings)
+	if err := loadMacroRegistry(config.MacroFile); err != nil {
+		return err
+	}
+	reloader := newReloader(cmdconfig.ConfigFile, config)
+	if len(config.Devices) > 0 {
+		if len(cmdconfig.DevicePaths) > 0 {
+			return fmt.Errorf("%q contains 'devices'. Do not pass devices on the command line.", cmdconfig.ConfigFile)
+		}
+		names := make([]string, 0, len(config.Devices))
+		for _, d := range config.Devices {
+			names = append(names, d.Name)
+		}
+		ctl := newEngineControl(strings.Join(names, ", "), reloader.subscribe())
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		if err := startControlServer(ctx, config, reloader, []*engineControl{ctl}); err != nil {
+			return err
+		}
+		go reloader.run(ctx)
+		return mergedMain(ctx, config, ctl)
+	}
 	if len(cmdconfig.DevicePaths) == 0 {
 		p, err := findDev()
 		if err != nil {
@@ -62,10 +98,6 @@ func CombosMain(ctx context.Context, cmdconfig CombosCmdConfig) error {
 		fmt.Printf("%s %s %q\n", usingDeviceMessage, alias, p)
 		cmdconfig.DevicePaths = []string{p}
 	}
-	combos, err := LoadYamlFile(cmdconfig.ConfigFile)
-	if err != nil {
-		return err
-	}
 
 	devices := make([]*device, 0, len(cmdconfig.DevicePaths))
 	good := 0
@@ -89,9 +121,18 @@ func CombosMain(ctx context.Context, cmdconfig CombosCmdConfig) error {
 	}
 	ctx, cancel := context.WithCancelCause(context.Background())
 	errorChannel := make(chan error)
+	engines := make([]*engineControl, 0, len(devices))
+	for _, dev := range devices {
+		engines = append(engines, newEngineControl(dev.path, reloader.subscribe()))
+	}
+	if err := startControlServer(ctx, config, reloader, engines); err != nil {
+		cancel(err)
+		return err
+	}
 	for i := 0; i < len(devices); i++ {
-		go handleOneDevice(ctx, combos, devices[i], errorChannel)
+		go handleOneDevice(ctx, config, engines[i], devices[i], errorChannel)
 	}
+	go reloader.run(ctx)
 	err = <-errorChannel
 	fmt.Printf("error, stopping now: %v\n", err)
 	cancel(err)
@@ -99,5 +140,13 @@ func CombosMain(ctx context.Context, cmdconfig CombosCmdConfig) error {
 		err := <-errorChannel
 		fmt.Println(err)
 	}
+	if errors.Is(err, ErrEmergencyExit) {
+		for _, dev := range devices {
+			if err := dev.ungrab(); err != nil {
+				fmt.Printf("failed to ungrab %q: %v\n", dev.path, err)
+			}
+		}
+		return ErrEmergencyExit
+	}
 	return nil
 }
@@ -0,0 +1,417 @@
+package tff
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// DefaultControlSocket is the default path of the control socket of 'tff ctl'.
+const DefaultControlSocket = "/run/tff.sock"
+
+// controlCallTimeout: how long the control socket waits for an engine.
+const controlCallTimeout = 2 * time.Second
+
+// ControlCommands are the commands of the control socket.
+var ControlCommands = []string{"status", "pause", "resume", "reload", "combos", "enable", "disable"}
+
+// engineControl connects a running engine (manInTheMiddle) with the reloader and the control
+// socket. The engine reads both channels in its own goroutine. This way the State gets changed
+// by one goroutine only.
+type engineControl struct {
+	// name of the engine: the device path(s).
+	name string
+
+	configs <-chan *Config
+	calls   chan func(*State)
+}
+
+func newEngineControl(name string, configs <-chan *Config) *engineControl {
+	return &engineControl{
+		name:    name,
+		configs: configs,
+		calls:   make(chan func(*State)),
+	}
+}
+
+// call runs fn in the goroutine of the engine, and waits until fn is done.
+func (e *engineControl) call(fn func(*State)) error {
+	done := make(chan struct{})
+	select {
+	case e.calls <- func(state *State) {
+		defer close(done)
+		fn(state)
+	}:
+	case <-time.After(controlCallTimeout):
+		return fmt.Errorf("engine %q does not respond", e.name)
+	}
+	<-done
+	return nil
+}
+
+// controlRequest is one line of JSON, sent by the client.
+type controlRequest struct {
+	Command string `json:"command"`
+	Group   string `json:"group,omitempty"`
+}
+
+// controlResponse is one line of JSON, sent by the server.
+type controlResponse struct {
+	Lines []string `json:"lines,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// controlServer handles the requests of 'tff ctl'. Each connection sends one request.
+type controlServer struct {
+	engines  []*engineControl
+	reloader *reloader
+
+	// gid: members of this group may use the socket. -1 means: only root and the user of the
+	// daemon.
+	gid int
+}
+
+// listenControlSocket creates the socket. Only the owner can use it. If group is set, members
+// of the group can use it, too.
+func listenControlSocket(path, group string) (net.Listener, int, error) {
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, 0, fmt.Errorf("control socket: %w", err)
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return nil, 0, fmt.Errorf("control socket: invalid gid %q of group %q", g.Gid, group)
+		}
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		// The socket of a previous run.
+		if err := os.Remove(path); err != nil {
+			return nil, 0, fmt.Errorf("control socket: %w", err)
+		}
+	}
+	// The socket must not be accessible by others, not even for a short time.
+	oldMask := syscall.Umask(0o177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, 0, fmt.Errorf("control socket: %w", err)
+	}
+	if gid != -1 {
+		if err := errors.Join(os.Chown(path, -1, gid), os.Chmod(path, 0o660)); err != nil {
+			listener.Close()
+			return nil, 0, fmt.Errorf("control socket: %w", err)
+		}
+	}
+	return listener, gid, nil
+}
+
+// startControlServer starts the control socket, if it is configured.
+func startControlServer(ctx context.Context, config *Config, reloader *reloader, engines []*engineControl) error {
+	if config.ControlSocket == "" {
+		return nil
+	}
+	listener, gid, err := listenControlSocket(config.ControlSocket, config.ControlGroup)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Control socket: %s\n", config.ControlSocket)
+	s := controlServer{
+		engines:  engines,
+		reloader: reloader,
+		gid:      gid,
+	}
+	go s.serve(ctx, listener)
+	return nil
+}
+
+func (s *controlServer) serve(ctx context.Context, listener net.Listener) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Printf("control socket: %v\n", err)
+			}
+			return
+		}
+		go s.handleConn(conn.(*net.UnixConn))
+	}
+}
+
+func (s *controlServer) handleConn(conn *net.UnixConn) {
+	defer conn.Close()
+	var resp controlResponse
+	cred, err := peerCred(conn)
+	switch {
+	case err != nil:
+		resp.Error = err.Error()
+	case !credAllowed(cred, s.gid, procGroups(cred.Pid)):
+		resp.Error = fmt.Sprintf("permission denied for uid %d", cred.Uid)
+	default:
+		var req controlRequest
+		// A client which never sends a newline must not keep the connection open.
+		conn.SetDeadline(time.Now().Add(controlCallTimeout))
+		line, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err == nil {
+			err = json.Unmarshal(line, &req)
+		}
+		if err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+			break
+		}
+		fmt.Printf("\ncontrol socket: %s %s (uid %d)\n", req.Command, req.Group, cred.Uid)
+		resp.Lines, err = s.handle(req)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	data, _ := json.Marshal(resp)
+	conn.SetWriteDeadline(time.Now().Add(controlCallTimeout))
+	conn.Write(append(data, '\n'))
+}
+
+func peerCred(conn *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+// credAllowed: root, the user of the daemon, and members of the group gid may use the socket.
+// The permissions of the socket file check the same. This check is the second line of defense.
+func credAllowed(cred *syscall.Ucred, gid int, groups []int) bool {
+	if cred.Uid == 0 || int(cred.Uid) == os.Getuid() {
+		return true
+	}
+	if gid == -1 {
+		return false
+	}
+	return int(cred.Gid) == gid || slices.Contains(groups, gid)
+}
+
+// procGroups returns the supplementary groups of a process.
+func procGroups(pid int32) []int {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields, found := strings.CutPrefix(line, "Groups:")
+		if !found {
+			continue
+		}
+		var groups []int
+		for _, field := range strings.Fields(fields) {
+			if gid, err := strconv.Atoi(field); err == nil {
+				groups = append(groups, gid)
+			}
+		}
+		return groups
+	}
+	return nil
+}
+
+func (s *controlServer) handle(req controlRequest) ([]string, error) {
+	switch req.Command {
+	case "status":
+		var lines []string
+		err := s.callEngines(func(e *engineControl, state *State) {
+			lines = append(lines, state.statusLines(e.name)...)
+		})
+		return lines, err
+	case "pause":
+		return []string{"paused. Keys which are pending or held get handled first."}, s.callEngines(func(_ *engineControl, state *State) {
+			state.pause()
+		})
+	case "resume":
+		return []string{"resumed"}, s.callEngines(func(_ *engineControl, state *State) {
+			state.resume()
+		})
+	case "reload":
+		if err := s.reloader.reload("control socket"); err != nil {
+			return nil, err
+		}
+		return []string{"new config loaded. It gets used, as soon as no key is pending or held."}, nil
+	case "combos":
+		var lines []string
+		err := s.engines[0].call(func(state *State) {
+			lines = state.comboLines()
+		})
+		return lines, err
+	case "enable", "disable":
+		if req.Group == "" {
+			return nil, fmt.Errorf("%q needs the name of a group", req.Command)
+		}
+		var errs []error
+		err := s.callEngines(func(e *engineControl, state *State) {
+			if !state.config.hasGroup(req.Group) {
+				errs = append(errs, fmt.Errorf("unknown group %q", req.Group))
+				return
+			}
+			state.disabledGroups[req.Group] = req.Command == "disable"
+		})
+		if err := errors.Join(append(errs, err)...); err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("group %q: %sd", req.Group, req.Command)}, nil
+	default:
+		return nil, fmt.Errorf("unknown command %q. Valid commands: %s", req.Command,
+			strings.Join(ControlCommands, ", "))
+	}
+}
+
+func (s *controlServer) callEngines(fn func(e *engineControl, state *State)) error {
+	var errs []error
+	for _, e := range s.engines {
+		errs = append(errs, e.call(func(state *State) {
+			fn(e, state)
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+func (state *State) statusLines(name string) []string {
+	layers := make([]string, 0, len(state.layerStack))
+	for _, layer := range state.layerStack {
+		layers = append(layers, layer.Name)
+	}
+	var disabled []string
+	for group, isDisabled := range state.disabledGroups {
+		if isDisabled {
+			disabled = append(disabled, group)
+		}
+	}
+	slices.Sort(disabled)
+	paused := "no"
+	switch {
+	case state.paused:
+		paused = "yes"
+	case state.pausePending:
+		paused = "as soon as no key is pending or held"
+	}
+	return []string{
+		fmt.Sprintf("engine %s", name),
+		fmt.Sprintf("  active layers: %s", strings.Join(layers, ", ")),
+		fmt.Sprintf("  paused: %s", paused),
+		fmt.Sprintf("  disabled groups: %s", strings.Join(disabled, ", ")),
+		fmt.Sprintf("  state: %s", state.String()),
+	}
+}
+
+func (state *State) comboLines() []string {
+	var lines []string
+	for _, layer := range state.config.Layers {
+		for _, combo := range layer.Combos {
+			line := fmt.Sprintf("%s: line %d: %s", layer.Name, combo.Line, combo.String())
+			if combo.Group != "" {
+				line += fmt.Sprintf(" (group %s", combo.Group)
+				if state.disabledGroups[combo.Group] {
+					line += ", disabled"
+				}
+				line += ")"
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (c *Config) hasGroup(group string) bool {
+	for _, layer := range c.Layers {
+		for _, combo := range layer.Combos {
+			if combo.Group == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pause: all keys get passed through, as soon as the engine is idle.
+func (state *State) pause() {
+	if !state.paused {
+		state.pausePending = true
+	}
+}
+
+func (state *State) resume() {
+	state.pausePending = false
+	state.paused = false
+}
+
+// passThrough writes key events unmodified, while the engine is paused. Keys which were pressed
+// while paused get passed through until they get released, even if the engine was resumed.
+func (state *State) passThrough(ev *Event) (bool, error) {
+	if ev.Type != evdev.EV_KEY || (!state.paused && !state.passthroughKeys[ev.Code]) {
+		return false, nil
+	}
+	switch ev.Value {
+	case DOWN:
+		state.passthroughKeys[ev.Code] = true
+	case UP:
+		delete(state.passthroughKeys, ev.Code)
+	}
+	return true, state.WriteEvent(*ev, "Paused")
+}
+
+// CtlMain sends one command to the control socket of a running 'tff combos', and prints the
+// response.
+func CtlMain(socket string, args []string) error {
+	req := controlRequest{Command: args[0]}
+	if len(args) > 1 {
+		req.Group = args[1]
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the control socket. Is 'controlSocket' set in combos.yaml? %w", err)
+	}
+	defer conn.Close()
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read the response: %w", err)
+	}
+	var resp controlResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	for _, line := range resp.Lines {
+		fmt.Println(line)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package tff
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+const controlTestYaml = `
+combos:
+  - keys: f j
+    outKeys: x
+    group: letters
+  - keys: d k
+    outKeys: y
+`
+
+type controlTest struct {
+	socket string
+	er     *chanReader
+	ew     *syncWriter
+	now    time.Time
+}
+
+// startControlTest starts one engine and the control socket.
+func startControlTest(t *testing.T) *controlTest {
+	file := filepath.Join(t.TempDir(), "combos.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(controlTestYaml), 0o600))
+	config, err := LoadYamlFile(file)
+	require.NoError(t, err)
+	config.ControlSocket = filepath.Join(t.TempDir(), "tff.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reloader := newReloader(file, config)
+	ctl := newEngineControl("test-device", reloader.subscribe())
+	require.NoError(t, startControlServer(ctx, config, reloader, []*engineControl{ctl}))
+	ct := controlTest{
+		socket: config.ControlSocket,
+		er:     &chanReader{ch: make(chan *Event, 100)},
+		ew:     &syncWriter{},
+		now:    time.Now(),
+	}
+	done := make(chan error)
+	go func() {
+		done <- manInTheMiddle(ctx, ct.er, ct.ew, config, VirtualClock{}, ctl)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+	return &ct
+}
+
+func (ct *controlTest) request(t *testing.T, command, group string) controlResponse {
+	conn, err := net.Dial("unix", ct.socket)
+	require.NoError(t, err)
+	defer conn.Close()
+	data, err := json.Marshal(controlRequest{Command: command, Group: group})
+	require.NoError(t, err)
+	_, err = conn.Write(append(data, '\n'))
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	require.NoError(t, err)
+	var resp controlResponse
+	require.NoError(t, json.Unmarshal(line, &resp))
+	return resp
+}
+
+// send passes the events of the state string to the engine, and waits until the engine wrote
+// n key events in total.
+func (ct *controlTest) send(t *testing.T, stateString string, n int) {
+	events, err := stateStringToSlice(stateString)
+	require.NoError(t, err)
+	for _, ev := range events {
+		ev.Time = timeToSyscallTimeval(ct.now.Add(syscallTimevalToTime(ev.Time).Sub(syscallTimevalToTime(events[0].Time))))
+		ct.er.ch <- &ev
+	}
+	ct.now = ct.now.Add(time.Minute)
+	require.Eventually(t, func() bool { return ct.ew.keyLen() == n }, 2*time.Second, time.Millisecond)
+}
+
+// keyLen returns the number of written EV_KEY events.
+func (w *syncWriter) keyLen() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for _, ev := range w.s {
+		if ev.Type == evdev.EV_KEY {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_ControlSocket(t *testing.T) {
+	ct := startControlTest(t)
+
+	fi, err := os.Stat(ct.socket)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	resp := ct.request(t, "status", "")
+	require.Empty(t, resp.Error)
+	require.Equal(t, []string{
+		"engine test-device",
+		"  active layers: base",
+		"  paused: no",
+		"  disabled groups: ",
+		"  state: buf is empty downKeysWritten: [] swallowKeys: []",
+	}, resp.Lines)
+
+	resp = ct.request(t, "combos", "")
+	require.Equal(t, []string{
+		"base: line 3: KEY_F KEY_J -> KEY_X (group letters)",
+		"base: line 6: KEY_D KEY_K -> KEY_Y",
+	}, resp.Lines)
+
+	require.Equal(t, `unknown group "digits"`, ct.request(t, "disable", "digits").Error)
+	require.Equal(t, `"disable" needs the name of a group`, ct.request(t, "disable", "").Error)
+	require.Contains(t, ct.request(t, "jump", "").Error, `unknown command "jump"`)
+
+	// A disabled group.
+	require.Empty(t, ct.request(t, "disable", "letters").Error)
+	require.Contains(t, ct.request(t, "combos", "").Lines, "base: line 3: KEY_F KEY_J -> KEY_X (group letters, disabled)")
+	ct.send(t, "f_ (20ms) j_ (200ms) j/ (20ms) f/", 4)
+	require.Empty(t, ct.request(t, "enable", "letters").Error)
+	ct.send(t, "f_ (20ms) j_ (200ms) j/ (20ms) f/", 6)
+
+	// Paused: keys get passed through.
+	require.Empty(t, ct.request(t, "pause", "").Error)
+	require.Contains(t, ct.request(t, "status", "").Lines, "  paused: yes")
+	ct.send(t, "d_ (20ms) k_ (200ms) k/ (20ms) d/", 10)
+	require.Empty(t, ct.request(t, "resume", "").Error)
+	ct.send(t, "d_ (20ms) k_ (200ms) k/ (20ms) d/", 12)
+
+	require.Equal(t, "new config loaded. It gets used, as soon as no key is pending or held.",
+		ct.request(t, "reload", "").Lines[0])
+
+	ct.ew.requireEqual(t, `
+		F-down
+		J-down
+		J-up
+		F-up
+		X-down
+		X-up
+		D-down
+		K-down
+		K-up
+		D-up
+		Y-down
+		Y-up
+		`)
+
+	require.NoError(t, CtlMain(ct.socket, []string{"status"}))
+	require.ErrorContains(t, CtlMain(ct.socket, []string{"enable", "digits"}), `unknown group "digits"`)
+}
+
+func Test_ControlSocket_PauseWhileComboHeld(t *testing.T) {
+	ct := startControlTest(t)
+	ct.send(t, "f_ (20ms) j_", 0)
+	require.Eventually(t, func() bool {
+		return ct.request(t, "status", "").Lines[4] != "  state: buf is empty downKeysWritten: [] swallowKeys: []"
+	}, 2*time.Second, time.Millisecond)
+
+	// The pause starts, after the combo was released.
+	require.Empty(t, ct.request(t, "pause", "").Error)
+	require.Contains(t, ct.request(t, "status", "").Lines, "  paused: as soon as no key is pending or held")
+	ct.send(t, "j/ (20ms) f/", 2)
+	ct.send(t, "j_ (20ms) f_ (20ms) f/ (20ms) j/", 6)
+
+	// A key which was pressed while paused gets passed through until it gets released.
+	ct.send(t, "f_", 7)
+	require.Empty(t, ct.request(t, "resume", "").Error)
+	ct.send(t, "f/", 8)
+	ct.send(t, "f_ (20ms) j_ (200ms) j/ (20ms) f/", 10)
+	ct.ew.requireEqual(t, `
+		X-down
+		X-up
+		J-down
+		F-down
+		F-up
+		J-up
+		F-down
+		F-up
+		X-down
+		X-up
+		`)
+}
+
+func Test_credAllowed(t *testing.T) {
+	uid := uint32(os.Getuid())
+	require.True(t, credAllowed(&syscall.Ucred{Uid: 0, Gid: 0}, -1, nil))
+	require.True(t, credAllowed(&syscall.Ucred{Uid: uid, Gid: 4711}, -1, nil))
+	other := uid + 1000
+	require.False(t, credAllowed(&syscall.Ucred{Uid: other, Gid: 4711}, -1, nil))
+	require.True(t, credAllowed(&syscall.Ucred{Uid: other, Gid: 4711}, 4711, nil))
+	require.True(t, credAllowed(&syscall.Ucred{Uid: other, Gid: 100}, 4711, []int{4, 4711}))
+	require.False(t, credAllowed(&syscall.Ucred{Uid: other, Gid: 100}, 4711, []int{4}))
+}
+
+func Test_procGroups(t *testing.T) {
+	groups := procGroups(int32(os.Getpid()))
+	gids, err := os.Getgroups()
+	require.NoError(t, err)
+	require.ElementsMatch(t, gids, groups)
+}
+
+func Test_Control_SilentClient(t *testing.T) {
+	ct := startControlTest(t)
+	conn, err := net.Dial("unix", ct.socket)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The client sends no request. The server answers after the deadline and closes the connection.
+	start := time.Now()
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 2*controlCallTimeout)
+	var resp controlResponse
+	require.NoError(t, json.Unmarshal(line, &resp))
+	require.Contains(t, resp.Error, "invalid request")
+	require.Contains(t, resp.Error, "timeout")
+}
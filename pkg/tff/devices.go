@@ -0,0 +1,259 @@
+package tff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// DeviceConfig is an entry of 'devices' in combos.yaml. If devices are configured, the events
+// of all devices get merged into one engine. This way a combo can use keys of several devices.
+type DeviceConfig struct {
+	Name string
+	Path string
+
+	// Block contains keys which get dropped, if they come from this device.
+	Block []KeyCode
+}
+
+// splitKeyDevices removes the device names from the keys of a combo. Example: "f@pedal j"
+// returns "f j" and {"f": "pedal"}.
+func splitKeyDevices(str string) (string, map[string]string) {
+	str = strings.ReplaceAll(str, "{", " { ")
+	str = strings.ReplaceAll(str, "}", " } ")
+	words := strings.Fields(str)
+	var devices map[string]string
+	for i, word := range words {
+		key, device, found := strings.Cut(word, "@")
+		if !found {
+			continue
+		}
+		if devices == nil {
+			devices = make(map[string]string)
+		}
+		devices[key] = device
+		words[i] = key
+	}
+	return strings.Join(words, " "), devices
+}
+
+// keyDevicesOverlap returns false, if a key is restricted to different devices in both combos.
+func keyDevicesOverlap(a, b *Combo) bool {
+	for key, device := range a.KeyDevices {
+		other := b.KeyDevices[key]
+		if other != "" && other != device {
+			return false
+		}
+	}
+	return true
+}
+
+// deviceEventReader gets implemented by readers which know the device of each event.
+type deviceEventReader interface {
+	ReadOneWithDevice() (*Event, string, error)
+}
+
+func readOneWithDevice(er EventReader) (*Event, string, error) {
+	if dr, ok := er.(deviceEventReader); ok {
+		return dr.ReadOneWithDevice()
+	}
+	ev, err := er.ReadOne()
+	return ev, "", err
+}
+
+type deviceEvent struct {
+	ev     *Event
+	device string
+	err    error
+}
+
+// mergedReader merges the events of several devices. Each event keeps the name of its device.
+type mergedReader struct {
+	ch chan deviceEvent
+}
+
+var _ deviceEventReader = &mergedReader{}
+
+func newMergedReader(ctx context.Context, readers map[string]EventReader) *mergedReader {
+	r := mergedReader{ch: make(chan deviceEvent)}
+	for name, er := range readers {
+		r.add(ctx, name, er)
+	}
+	return &r
+}
+
+// add reads the events of a device. It can be called after the first events were read, for
+// example if the device was not present at startup.
+func (r *mergedReader) add(ctx context.Context, name string, er EventReader) {
+	go func() {
+		for {
+			ev, err := er.ReadOne()
+			if err != nil {
+				err = fmt.Errorf("device %q: %w", name, err)
+			}
+			select {
+			case r.ch <- deviceEvent{ev, name, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (r *mergedReader) ReadOneWithDevice() (*Event, string, error) {
+	de := <-r.ch
+	return de.ev, de.device, de.err
+}
+
+func (r *mergedReader) ReadOne() (*Event, error) {
+	ev, _, err := r.ReadOneWithDevice()
+	return ev, err
+}
+
+// setKeyDevice remembers the device of a pressed key.
+func (state *State) setKeyDevice(ev *Event, device string) {
+	if ev.Type != evdev.EV_KEY || ev.Value != DOWN || device == "" {
+		return
+	}
+	state.keyDevices[ev.Code] = device
+}
+
+// keyFromOtherDevice returns the first key of the buffer which was pressed on a device which
+// is not allowed by the combo.
+func (state *State) keyFromOtherDevice(combo *Combo) (KeyCode, bool) {
+	for _, ev := range state.buf {
+		device := combo.KeyDevices[ev.Code]
+		if ev.Value == DOWN && device != "" && state.keyDevices[ev.Code] != device {
+			return ev.Code, true
+		}
+	}
+	return 0, false
+}
+
+// mergedMain grabs all devices of the config, and runs one engine for all of them. The output
+// gets written to one new device, which has the capabilities of all devices which are present at
+// startup. Devices which are missing get opened as soon as they are present. At least one device
+// must be present at startup.
+func mergedMain(ctx context.Context, config *Config, ctl *engineControl) error {
+	readers := make(map[string]EventReader, len(config.Devices))
+	sources := make([]*evdev.InputDevice, 0, len(config.Devices))
+	defer func() {
+		for _, dev := range sources {
+			ungrabAndClose(dev)
+		}
+	}()
+	var missing []DeviceConfig
+	for _, deviceConfig := range config.Devices {
+		dev, err := openAndGrab(deviceConfig.Path)
+		if err != nil {
+			// retryOpen prints the error.
+			missing = append(missing, deviceConfig)
+			continue
+		}
+		sources = append(sources, dev)
+		readers[deviceConfig.Name] = dev
+		fmt.Printf("%s %q (%s)\n", usingDeviceMessage, deviceConfig.Path, deviceConfig.Name)
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("none of the devices in 'devices' is present")
+	}
+	outDev, err := createOutputDevice(fmt.Sprintf("tff-merged-%d", os.Getpid()), sources)
+	if err != nil {
+		return err
+	}
+	defer outDev.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r := newMergedReader(ctx, readers)
+	for _, deviceConfig := range missing {
+		go func() {
+			var dev *evdev.InputDevice
+			err := retryOpen(ctx, deviceConfig.Path, func() (err error) {
+				dev, err = openAndGrab(deviceConfig.Path)
+				return err
+			})
+			if err != nil {
+				return
+			}
+			defer ungrabAndClose(dev)
+			fmt.Printf("%s %q (%s)\n", usingDeviceMessage, deviceConfig.Path, deviceConfig.Name)
+			r.add(ctx, deviceConfig.Name, dev)
+			<-ctx.Done()
+		}()
+	}
+	return manInTheMiddle(ctx, r, outDev, config, RealClock{}, ctl)
+}
+
+// openAndGrab opens the device and grabs it.
+func openAndGrab(path string) (*evdev.InputDevice, error) {
+	dev, err := evdev.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := dev.Grab(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return dev, nil
+}
+
+func ungrabAndClose(dev *evdev.InputDevice) {
+	// Ungrab explicitly. After the emergency chord, the keyboard must be usable again, even if
+	// closing fails.
+	dev.Ungrab()
+	dev.Close()
+}
+
+// retryOpen calls open until it succeeds. After a failure, it waits sleepAfterOpenFailure. It
+// returns the error of the context, if the context is done.
+func retryOpen(ctx context.Context, path string, open func() error) error {
+	for {
+		err := open()
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("failed to open %q. Will wait %s. %s\n", path, sleepAfterOpenFailure, err.Error())
+
+		// sleep, but check if the context is done.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleepAfterOpenFailure):
+		}
+	}
+}
+
+// createOutputDevice creates an output device with the capabilities of all sources, and the
+// capabilities which are needed for mouse actions.
+func createOutputDevice(name string, sources []*evdev.InputDevice) (*evdev.InputDevice, error) {
+	capabilities := make(map[evdev.EvType][]evdev.EvCode)
+	for evType, codes := range mouseCapabilities {
+		capabilities[evType] = slices.Clone(codes)
+	}
+	for _, dev := range sources {
+		for _, evType := range dev.CapableTypes() {
+			for _, code := range dev.CapableEvents(evType) {
+				if !slices.Contains(capabilities[evType], code) {
+					capabilities[evType] = append(capabilities[evType], code)
+				}
+			}
+		}
+	}
+	id, err := sources[0].InputID()
+	if err != nil {
+		return nil, err
+	}
+	outDev, err := evdev.CreateDevice(name, id, capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output device: %w", err)
+	}
+	return outDev, nil
+}
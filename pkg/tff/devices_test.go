@@ -0,0 +1,181 @@
+package tff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitKeyDevices(t *testing.T) {
+	keys, devices := splitKeyDevices("f@pedal {j k@laptop}")
+	require.Equal(t, "f { j k }", keys)
+	require.Equal(t, map[string]string{"f": "pedal", "k": "laptop"}, devices)
+
+	keys, devices = splitKeyDevices("f j")
+	require.Equal(t, "f j", keys)
+	require.Nil(t, devices)
+}
+
+// deviceSliceReader returns the events of a state string. The device of each event is given
+// with the prefix "device:", for example "pedal:f_ (50ms) laptop:j_".
+type deviceSliceReader struct {
+	readFromSlice
+	devices []string
+}
+
+func newDeviceSliceReader(t *testing.T, input string) *deviceSliceReader {
+	parts := strings.Fields(input)
+	var devices []string
+	for i, part := range parts {
+		if i%2 == 1 {
+			continue
+		}
+		device, key, _ := strings.Cut(part, ":")
+		devices = append(devices, device)
+		parts[i] = key
+	}
+	r := deviceSliceReader{devices: devices}
+	require.NoError(t, r.loadStateString(strings.Join(parts, " ")))
+	return &r
+}
+
+func (r *deviceSliceReader) ReadOneWithDevice() (*Event, string, error) {
+	ev, err := r.ReadOne()
+	if err != nil {
+		return nil, "", err
+	}
+	device := r.devices[0]
+	r.devices = r.devices[1:]
+	return ev, device, nil
+}
+
+var _ deviceEventReader = &deviceSliceReader{}
+
+var devicesYaml = `
+devices:
+  - name: pedal
+    path: /dev/input/by-id/usb-pedal-event-kbd
+  - name: laptop
+    path: /dev/input/by-path/platform-i8042-serio-0-event-kbd
+combos:
+  - keys: f@pedal j
+    outKeys: x
+  - keys: j k
+    outKeys: y
+`
+
+func assertDevicesInputOutput(t *testing.T, input string, expected string) {
+	t.Helper()
+	config, err := LoadYamlFromBytes([]byte(devicesYaml))
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	err = manInTheMiddle(context.Background(), newDeviceSliceReader(t, input), &ew, config, VirtualClock{}, nil)
+	require.NoError(t, err)
+	ew.requireEqual(t, expected)
+}
+
+func Test_Devices_ComboAcrossDevices(t *testing.T) {
+	assertDevicesInputOutput(t,
+		`pedal:f_ (50ms) laptop:j_ (200ms) laptop:j/ (10ms) pedal:f/`,
+		`
+		X-down
+		X-up
+	`)
+}
+
+func Test_Devices_KeyFromOtherDevice(t *testing.T) {
+	assertDevicesInputOutput(t,
+		`laptop:f_ (50ms) laptop:j_ (200ms) laptop:j/ (10ms) laptop:f/`,
+		`
+		F-down
+		J-down
+		J-up
+		F-up
+	`)
+}
+
+func Test_Devices_ComboWithoutDevice(t *testing.T) {
+	assertDevicesInputOutput(t,
+		`pedal:j_ (50ms) laptop:k_ (200ms) laptop:k/ (10ms) pedal:j/`,
+		`
+		Y-down
+		Y-up
+	`)
+}
+
+func Test_mergedReader(t *testing.T) {
+	pedal, err := NewReadFromSliceInputStateString("f_ (10ms) f/")
+	require.NoError(t, err)
+	laptop, err := NewReadFromSliceInputStateString("j_ (10ms) j/")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newMergedReader(ctx, map[string]EventReader{"pedal": pedal, "laptop": laptop})
+	counts := map[string]int{}
+	var eofDevices []string
+	for len(eofDevices) < 2 {
+		ev, device, err := r.ReadOneWithDevice()
+		if errors.Is(err, io.EOF) {
+			eofDevices = append(eofDevices, device)
+			continue
+		}
+		require.NoError(t, err)
+		counts[device+":"+eventToString(ev, nil)]++
+	}
+	require.Equal(t, map[string]int{"pedal:f_": 1, "pedal:f/": 1, "laptop:j_": 1, "laptop:j/": 1}, counts)
+	require.ElementsMatch(t, []string{"pedal", "laptop"}, eofDevices)
+}
+
+func Test_mergedReader_addLater(t *testing.T) {
+	// The laptop was not present at startup.
+	pedal, err := NewReadFromSliceInputStateString("f_")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newMergedReader(ctx, map[string]EventReader{"pedal": pedal})
+	ev, device, err := r.ReadOneWithDevice()
+	require.NoError(t, err)
+	require.Equal(t, "pedal:f_", device+":"+eventToString(ev, nil))
+
+	laptop, err := NewReadFromSliceInputStateString("j_")
+	require.NoError(t, err)
+	r.add(ctx, "laptop", laptop)
+	counts := map[string]int{}
+	for range 3 {
+		ev, device, err := r.ReadOneWithDevice()
+		if errors.Is(err, io.EOF) {
+			counts[device+":EOF"]++
+			continue
+		}
+		require.NoError(t, err)
+		counts[device+":"+eventToString(ev, nil)]++
+	}
+	require.Equal(t, map[string]int{"pedal:EOF": 1, "laptop:j_": 1, "laptop:EOF": 1}, counts)
+}
+
+func Test_retryOpen(t *testing.T) {
+	defer func(d time.Duration) { sleepAfterOpenFailure = d }(sleepAfterOpenFailure)
+	sleepAfterOpenFailure = time.Millisecond
+
+	calls := 0
+	err := retryOpen(context.Background(), "/dev/input/pedal", func() error {
+		calls++
+		if calls < 3 {
+			return os.ErrNotExist
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = retryOpen(ctx, "/dev/input/pedal", func() error { return os.ErrNotExist })
+	require.ErrorIs(t, err, context.Canceled)
+}
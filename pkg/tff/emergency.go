@@ -0,0 +1,65 @@
+package tff
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// The emergency chord: if both shift keys and esc are held for EmergencyHoldTime, tff releases
+// all keys it pressed, ungrabs the devices and exits. The chord gets detected on the events of
+// the devices, before block, pause, remaps and combos. It can not be disabled by the config.
+var emergencyChord = []KeyCode{evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT, evdev.KEY_ESC}
+
+const EmergencyHoldTime = 3 * time.Second
+
+// EmergencyExitCode is the exit code of 'tff combos' after the emergency chord. The systemd
+// service does not restart tff on this exit code.
+const EmergencyExitCode = 3
+
+var ErrEmergencyExit = errors.New("emergency chord (left shift, right shift and esc) was held. Exiting")
+
+// trackEmergencyChord gets called for each event of the devices. If all keys of the chord are
+// down, the emergency timer gets started.
+func (state *State) trackEmergencyChord(ev *Event) {
+	if ev.Type != evdev.EV_KEY || !slices.Contains(emergencyChord, ev.Code) {
+		return
+	}
+	switch ev.Value {
+	case DOWN:
+		state.emergencyKeysDown[ev.Code] = true
+	case UP:
+		delete(state.emergencyKeysDown, ev.Code)
+	default:
+		return
+	}
+	if len(state.emergencyKeysDown) < len(emergencyChord) {
+		state.emergencyDeadline = maxTime
+		return
+	}
+	state.emergencyDeadline = syscallTimevalToTime(ev.Time).Add(EmergencyHoldTime)
+}
+
+// emergencyExit releases all keys which tff has pressed on the output device.
+func (state *State) emergencyExit(t time.Time) error {
+	fmt.Printf("\n%s\n", ErrEmergencyExit.Error())
+	// Queued output must not press keys again.
+	state.outputQueue = nil
+	state.outputDeadline = maxTime
+	for _, key := range slices.Sorted(maps.Keys(state.keysDown)) {
+		err := state.WriteEvent(Event{
+			Time:  timeToSyscallTimeval(t),
+			Type:  evdev.EV_KEY,
+			Code:  key,
+			Value: UP,
+		}, "Emergency")
+		if err != nil {
+			return errors.Join(ErrEmergencyExit, err)
+		}
+	}
+	return ErrEmergencyExit
+}
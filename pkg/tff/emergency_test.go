@@ -0,0 +1,46 @@
+package tff
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const emergencyTestYaml = `
+block:
+  - esc
+combos:
+  - keys: f j
+    outKeys: x
+`
+
+func Test_EmergencyChord(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(emergencyTestYaml))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString("a_ (20ms) leftshift_ (20ms) rightshift_ (20ms) esc_ (1s) esc= (1s) esc= (1100ms) esc= (20ms) b_")
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	err = manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil)
+	require.ErrorIs(t, err, ErrEmergencyExit)
+
+	// esc is blocked by the config. The chord works nevertheless. All keys get released.
+	ew.requireEqual(t, `
+		A-down
+		LEFTSHIFT-down
+		RIGHTSHIFT-down
+		A-up
+		LEFTSHIFT-up
+		RIGHTSHIFT-up
+		`)
+}
+
+func Test_EmergencyChord_ReleasedTooEarly(t *testing.T) {
+	AssertYamlStateStringInputOutput(t, "leftshift_ (20ms) rightshift_ (20ms) esc_ (1s) esc= (1s) esc/ (20ms) esc_ (1s) esc= (1s) esc/ (20ms) rightshift/ (20ms) leftshift/",
+		`
+		LEFTSHIFT-down
+		RIGHTSHIFT-down
+		RIGHTSHIFT-up
+		LEFTSHIFT-up
+		`, emergencyTestYaml)
+}
@@ -0,0 +1,340 @@
+package tff
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// BaseLayerName is the name of the layer which gets defined at the top-level of combos.yaml.
+const BaseLayerName = "base"
+
+// Config is the parsed combos.yaml.
+type Config struct {
+	// Layers[0] is the base layer.
+	Layers []*Layer
+
+	// Timing of the combo engine. Zero values mean "use DefaultTiming".
+	Timing Timing
+
+	// OutputPacing is the pause before each key event written for a combo. Combos can override it.
+	OutputPacing time.Duration
+
+	// Repeat configures the autorepeat. Zero values mean "forward the repeat events of the kernel".
+	Repeat Repeat
+
+	// Devices: if set, the events of these devices get merged into one engine.
+	Devices []DeviceConfig
+
+	// Block contains keys which get dropped before they reach the engine. DeviceConfig.Block
+	// contains additional keys per device.
+	Block []KeyCode
+
+	// CountBlocked: count how often each blocked key was pressed.
+	CountBlocked bool
+
+	// ControlSocket is the path of the socket for 'tff ctl'. Empty means: no control socket.
+	ControlSocket string
+
+	// ControlGroup: members of this group may use the control socket. Empty means: only root
+	// and the user of the daemon.
+	ControlGroup string
+
+	// Layout: keys get shown with the characters of the keycaps. nil means: names of the evdev
+	// codes.
+	Layout *keyboardLayout
+
+	// Mouse configures the speed of mouse actions.
+	Mouse Mouse
+
+	// MacroFile stores the macro registers between sessions. Empty means: macros are not saved.
+	MacroFile string
+
+	// StatsFile collects usage statistics. Empty means: no statistics.
+	StatsFile string
+
+	// Training reacts on keys which are pressed directly, although a combo produces them. nil
+	// means: no training.
+	Training *Training
+
+	// Findings of checkConfig. Set by LoadYamlFromBytes.
+	Findings []Finding
+}
+
+// NewConfigFromCombos creates a config which contains only a base layer with the given combos.
+func NewConfigFromCombos(combos []*Combo) *Config {
+	layer := &Layer{
+		Name:   BaseLayerName,
+		Remaps: map[KeyCode]*Remap{},
+		Combos: combos,
+	}
+	layer.buildComboIndex()
+	return &Config{
+		Layers: []*Layer{layer},
+		Mouse:  DefaultMouse,
+	}
+}
+
+func (c *Config) BaseLayer() *Layer {
+	return c.Layers[0]
+}
+
+// LayerByName returns nil if no layer with the given name exists.
+func (c *Config) LayerByName(name string) *Layer {
+	for _, layer := range c.Layers {
+		if layer.Name == name {
+			return layer
+		}
+	}
+	return nil
+}
+
+// maxComboLength returns the number of keys of the longest combo of all layers.
+func (c *Config) maxComboLength() int {
+	maxLength := 0
+	for _, layer := range c.Layers {
+		for _, combo := range layer.Combos {
+			maxLength = max(maxLength, len(combo.Keys))
+		}
+	}
+	return maxLength
+}
+
+func (c *Config) isEmpty() bool {
+	if len(c.Block) > 0 || slices.ContainsFunc(c.Devices, func(d DeviceConfig) bool {
+		return len(d.Block) > 0
+	}) {
+		return false
+	}
+	for _, layer := range c.Layers {
+		if len(layer.Combos) > 0 || len(layer.Remaps) > 0 || len(layer.Sequences) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Layer is a named set of remaps and combos. The base layer is always active. Other layers
+// get activated via layer actions.
+type Layer struct {
+	Name      string
+	Remaps    map[KeyCode]*Remap
+	Combos    []*Combo
+	Sequences []*Sequence
+
+	// Line in combos.yaml. Zero for the base layer.
+	Line int
+
+	// comboIndex contains the combos which contain a key. Created by buildComboIndex.
+	comboIndex map[KeyCode][]*Combo
+}
+
+// buildComboIndex must be called after Combos was changed.
+func (l *Layer) buildComboIndex() {
+	l.comboIndex = make(map[KeyCode][]*Combo)
+	for _, combo := range l.Combos {
+		for _, key := range combo.Keys {
+			l.comboIndex[key] = append(l.comboIndex[key], combo)
+		}
+	}
+}
+
+// combosWithKey returns the combos which contain the key, in the order of Combos.
+func (l *Layer) combosWithKey(key KeyCode) []*Combo {
+	return l.comboIndex[key]
+}
+
+type LayerActionType string
+
+const (
+	// LayerHold activates the layer while the key is held.
+	LayerHold LayerActionType = "layer-hold"
+
+	// LayerToggle activates the layer on the first press, and deactivates it on the next press.
+	LayerToggle LayerActionType = "layer-toggle"
+
+	// LayerLock deactivates all layers (except the base layer) and activates the layer.
+	// Use "layer-lock base" to get back to the base layer.
+	LayerLock LayerActionType = "layer-lock"
+)
+
+type LayerAction struct {
+	Type  LayerActionType
+	Layer string
+}
+
+func (a *LayerAction) String() string {
+	return fmt.Sprintf("%s %s", a.Type, a.Layer)
+}
+
+// Remap maps a single key to an other key (OutKey), to a layer action or to a tap-hold
+// behavior. Remapped keys do not get passed to the combo engine.
+type Remap struct {
+	Key     KeyCode
+	OutKey  KeyCode
+	Action  *LayerAction
+	TapHold *TapHold
+	Mouse   *MouseAction
+
+	// Line in combos.yaml. Zero, if the remap was not loaded from yaml.
+	Line int
+}
+
+func (state *State) activeLayer() *Layer {
+	return state.layerStack[len(state.layerStack)-1]
+}
+
+// findRemap searches the active layers from top to bottom. Keys which are not remapped in a
+// layer fall through to the layer below.
+func (state *State) findRemap(key KeyCode) *Remap {
+	for i := len(state.layerStack) - 1; i >= 0; i-- {
+		if remap, ok := state.layerStack[i].Remaps[key]; ok {
+			return remap
+		}
+	}
+	return nil
+}
+
+// HandleKey is the entry point for EV_KEY events with value UP or DOWN. Remaps and layer
+// actions get handled here, all other keys get passed to the combo engine.
+func (state *State) HandleKey(ev Event) error {
+	if state.tapHold != nil && state.tapHold.down.Code != ev.Code {
+		return state.queueForTapHold(ev)
+	}
+	if ev.Value == DOWN {
+		// Remember how the key was resolved. The up-event must be handled the same way,
+		// even if the active layer changed in between.
+		if remap := state.findRemap(ev.Code); remap != nil {
+			state.pressedRemaps[ev.Code] = remap
+		} else {
+			delete(state.pressedRemaps, ev.Code)
+		}
+	}
+	remap, ok := state.pressedRemaps[ev.Code]
+	if !ok {
+		handled, err := state.handleUnusedButton(ev)
+		if handled || err != nil {
+			return err
+		}
+		handled, err = state.handleModifierKey(ev)
+		if handled || err != nil {
+			return err
+		}
+		handled, err = state.handleSequenceKey(ev)
+		if handled || err != nil {
+			return err
+		}
+		switch ev.Value {
+		case UP:
+			err = state.HandleUpChar(ev)
+		case DOWN:
+			err = state.HandleDownChar(ev)
+		default:
+			return fmt.Errorf("Received %d. Expected UP or DOWN", ev.Value)
+		}
+		if err != nil {
+			return err
+		}
+		state.updateCombosOfActiveLayer()
+		return nil
+	}
+	if ev.Value == UP {
+		delete(state.pressedRemaps, ev.Code)
+	}
+	if remap.Action != nil {
+		return state.applyLayerAction(remap.Action, ev)
+	}
+	if remap.TapHold != nil {
+		return state.handleTapHoldKey(remap.TapHold, ev)
+	}
+	if remap.Mouse != nil {
+		if err := state.FlushBuffer("Mouse"); err != nil {
+			return err
+		}
+		return state.handleMouseAction(remap.Mouse, ev.Value, ev.Time)
+	}
+	ev.Code = remap.OutKey
+	return state.FlushBufferAndWriteEvent(ev, "Remap")
+}
+
+func (state *State) applyLayerAction(action *LayerAction, ev Event) error {
+	layer := state.config.LayerByName(action.Layer)
+	if layer == nil {
+		return fmt.Errorf("unknown layer %q", action.Layer)
+	}
+	switch action.Type {
+	case LayerHold:
+		if ev.Value == DOWN {
+			state.activateLayer(layer)
+		} else {
+			state.deactivateLayer(layer)
+		}
+	case LayerToggle:
+		if ev.Value != DOWN {
+			return nil
+		}
+		if slices.Contains(state.layerStack, layer) {
+			state.deactivateLayer(layer)
+		} else {
+			state.activateLayer(layer)
+		}
+	case LayerLock:
+		if ev.Value != DOWN {
+			return nil
+		}
+		state.layerStack = state.layerStack[:1]
+		state.activateLayer(layer)
+	default:
+		return fmt.Errorf("unknown layer action %q", action.Type)
+	}
+	fmt.Printf("  %s: active layer is %q\n", action.String(), state.activeLayer().Name)
+	state.updateCombosOfActiveLayer()
+	return nil
+}
+
+func (state *State) activateLayer(layer *Layer) {
+	if slices.Contains(state.layerStack, layer) {
+		return
+	}
+	state.layerStack = append(state.layerStack, layer)
+}
+
+func (state *State) deactivateLayer(layer *Layer) {
+	if layer == state.layerStack[0] {
+		// The base layer is always active.
+		return
+	}
+	state.layerStack = removeFromSlice(state.layerStack, layer)
+}
+
+// updateCombosOfActiveLayer switches the combo engine to the combos of the active layer.
+// This gets delayed until the buffer is empty and no combo is held down. Otherwise the
+// pending keys would be evaluated against the wrong combos.
+func (state *State) updateCombosOfActiveLayer() {
+	layer := state.activeLayer()
+	if state.combosLayer == layer {
+		return
+	}
+	if len(state.buf) > 0 || len(state.downKeysWritten) > 0 {
+		return
+	}
+	state.combosLayer = layer
+}
+
+// comboCandidates returns the combos which contain the first key of the buffer. All other combos
+// can not match: EvalCombo returns NoMatch, if the buffer contains a key which is not in the combo.
+func (state *State) comboCandidates() []*Combo {
+	if len(state.buf) == 0 {
+		return nil
+	}
+	return state.combosLayer.combosWithKey(state.buf[0].Code)
+}
+
+func (c *Config) deviceByName(name string) *DeviceConfig {
+	for i := range c.Devices {
+		if c.Devices[i].Name == name {
+			return &c.Devices[i]
+		}
+	}
+	return nil
+}
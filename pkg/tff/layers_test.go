@@ -0,0 +1,236 @@
+package tff
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+var navLayerYaml = `
+remaps:
+  - key: capslock
+    action: layer-hold nav
+  - key: f12
+    action: layer-toggle nav
+  - key: f11
+    action: layer-lock nav
+layers:
+  - name: nav
+    remaps:
+      - key: j
+        outKey: left
+      - key: l
+        outKey: right
+      - key: h
+        outKey: backspace
+      - key: f11
+        action: layer-lock base
+    combos:
+      - keys: d f
+        outKeys: home
+`
+
+func Test_Layer_Hold(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (100ms) j_ (50ms) j/ (100ms) h_ (50ms) h/ (100ms) capslock/ (100ms) j_ (50ms) j/
+	`,
+		`
+		LEFT-down
+		LEFT-up
+		BACKSPACE-down
+		BACKSPACE-up
+		J-down
+		J-up
+	`,
+		navLayerYaml)
+}
+
+func Test_Layer_Hold_UpAfterLayerKeyUp(t *testing.T) {
+	// The up-event of "j" must be remapped, too. Even if the layer is not active anymore.
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (100ms) j_ (50ms) capslock/ (50ms) j/
+	`,
+		`
+		LEFT-down
+		LEFT-up
+	`,
+		navLayerYaml)
+}
+
+func Test_Layer_Toggle(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f12_ (50ms) f12/ (100ms) l_ (50ms) l/ (100ms) f12_ (50ms) f12/ (100ms) l_ (50ms) l/
+	`,
+		`
+		RIGHT-down
+		RIGHT-up
+		L-down
+		L-up
+	`,
+		navLayerYaml)
+}
+
+func Test_Layer_Lock(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f11_ (50ms) f11/ (100ms) l_ (50ms) l/ (100ms) f11_ (50ms) f11/ (100ms) l_ (50ms) l/
+	`,
+		`
+		RIGHT-down
+		RIGHT-up
+		L-down
+		L-up
+	`,
+		navLayerYaml)
+}
+
+func Test_Layer_Combos(t *testing.T) {
+	// The combo "d f" is only defined in the layer "nav".
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (100ms) d_ (20ms) f_ (300ms) f/ (20ms) d/ (100ms) capslock/ (100ms) d_ (20ms) f_ (300ms) f/ (20ms) d/
+	`,
+		`
+		HOME-down
+		HOME-up
+		D-down
+		F-down
+		F-up
+		D-up
+	`,
+		navLayerYaml)
+}
+
+func Test_Layer_Combos_SwitchAfterRemapFlushedBuffer(t *testing.T) {
+	// "a" is in the buffer, when the layer gets activated. The remap of "j" flushes the buffer.
+	// The next key must use the combos of the layer "nav".
+	AssertYamlStateStringInputOutput(t,
+		`
+		a_ (10ms) capslock_ (160ms) j_ (10ms) j/ (10ms) d_ (20ms) f_ (300ms) f/ (20ms) d/ (10ms) a/ (100ms) capslock/
+	`,
+		`
+		A-down
+		LEFT-down
+		LEFT-up
+		HOME-down
+		HOME-up
+		A-up
+	`,
+		navLayerYaml+`
+combos:
+  - keys: a s
+    outKeys: x
+`)
+}
+
+var letterKeys = []KeyCode{
+	evdev.KEY_A, evdev.KEY_B, evdev.KEY_C, evdev.KEY_D, evdev.KEY_E, evdev.KEY_F, evdev.KEY_G,
+	evdev.KEY_H, evdev.KEY_I, evdev.KEY_J, evdev.KEY_K, evdev.KEY_L, evdev.KEY_M, evdev.KEY_N,
+	evdev.KEY_O, evdev.KEY_P, evdev.KEY_Q, evdev.KEY_R, evdev.KEY_S, evdev.KEY_T, evdev.KEY_U,
+	evdev.KEY_V, evdev.KEY_W, evdev.KEY_X, evdev.KEY_Y, evdev.KEY_Z,
+}
+
+// manyCombos returns n combos: first all pairs of letters, then triples.
+func manyCombos(n int) []*Combo {
+	combos := make([]*Combo, 0, n)
+	for _, a := range letterKeys {
+		for _, b := range letterKeys {
+			if a == b {
+				continue
+			}
+			combos = append(combos, &Combo{Keys: []KeyCode{a, b}, OutKeys: []KeyCode{evdev.KEY_1}})
+			if len(combos) == n {
+				return combos
+			}
+		}
+	}
+	for _, a := range letterKeys {
+		for _, b := range letterKeys {
+			for _, c := range letterKeys {
+				if a == b || b == c || a == c {
+					continue
+				}
+				combos = append(combos, &Combo{Keys: []KeyCode{a, b, c}, OutKeys: []KeyCode{evdev.KEY_2}})
+				if len(combos) == n {
+					return combos
+				}
+			}
+		}
+	}
+	return combos
+}
+
+func Test_comboCandidates_OtherCombosDoNotMatch(t *testing.T) {
+	// The combo index must give the same results as evaluating all combos.
+	r := rand.New(rand.NewSource(1))
+	combos := manyCombos(800)
+	for _, combo := range combos[:100] {
+		combo.KeyGroups = [][]KeyCode{combo.Keys}
+	}
+	state := NewState(NewConfigFromCombos(combos), &writeToSlice{})
+	start := time.Unix(1712500000, 0)
+	for range 1000 {
+		state.buf = state.buf[:0]
+		for i := range 1 + r.Intn(5) {
+			state.buf = append(state.buf, Event{
+				Time:  timeToSyscallTimeval(start.Add(time.Duration(i) * 30 * time.Millisecond)),
+				Type:  evdev.EV_KEY,
+				Code:  letterKeys[r.Intn(6)],
+				Value: int32(r.Intn(2)),
+			})
+		}
+		candidates := state.comboCandidates()
+		for _, combo := range combos {
+			if slices.Contains(candidates, combo) {
+				continue
+			}
+			code, msg, err := state.EvalCombo(combo, timeToSyscallTimeval(start.Add(time.Second)))
+			require.NoError(t, err)
+			require.Equal(t, NoMatch, code, "combo %s buf %s: %s", combo.String(), state.String(), msg)
+		}
+	}
+}
+
+// BenchmarkHandleKey measures the latency per event. The number of combos should not matter.
+func BenchmarkHandleKey(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("combos=%d", n), func(b *testing.B) {
+			stdout := os.Stdout
+			devNull, err := os.Open(os.DevNull)
+			require.NoError(b, err)
+			os.Stdout = devNull
+			defer func() {
+				os.Stdout = stdout
+				devNull.Close()
+			}()
+
+			state := NewState(NewConfigFromCombos(manyCombos(n)), &writeToSlice{})
+			// Fluent typing with some overlap, and a combo.
+			events, err := stateStringToSlice(`
+				h_ (30ms) e_ (20ms) h/ (40ms) e/ (30ms) l_ (50ms) l/ (30ms) o_ (50ms) o/
+				(100ms) f_ (50ms) j_ (200ms) j/ (10ms) f/`)
+			require.NoError(b, err)
+			b.ResetTimer()
+			for i := range b.N {
+				ev := events[i%len(events)]
+				offset := time.Duration(i/len(events)) * time.Second
+				ev.Time = timeToSyscallTimeval(syscallTimevalToTime(ev.Time).Add(offset))
+				if err := state.fireTimers(syscallTimevalToTime(ev.Time)); err != nil {
+					b.Fatal(err)
+				}
+				if err := state.HandleKey(ev); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
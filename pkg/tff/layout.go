@@ -0,0 +1,386 @@
+package tff
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// layoutKey is the key (and the modifiers) which types a character.
+type layoutKey struct {
+	Key   KeyCode
+	Shift bool
+	AltGr bool
+}
+
+func (k layoutKey) step() OutputStep {
+	keys := make([]KeyCode, 0, 3)
+	if k.AltGr {
+		keys = append(keys, evdev.KEY_RIGHTALT)
+	}
+	if k.Shift {
+		keys = append(keys, evdev.KEY_LEFTSHIFT)
+	}
+	return OutputStep{Keys: append(keys, k.Key)}
+}
+
+// keyboardLayout maps the characters which are printed on the keycaps to evdev codes.
+type keyboardLayout struct {
+	name  string
+	chars map[rune]layoutKey
+
+	// keycaps contains the character of each key without modifiers.
+	keycaps map[KeyCode]rune
+
+	// dead keys do not type a character on their own. They can be used in 'keys', but not in
+	// 'outText'.
+	dead string
+}
+
+// layoutRow: the characters of the keys without modifiers, with shift and with AltGr. A space
+// means: the key does not type a character. altGr can be empty.
+type layoutRow struct {
+	keys    []KeyCode
+	normal  string
+	shifted string
+	altGr   string
+}
+
+func newKeyboardLayout(name, dead string, rows []layoutRow) *keyboardLayout {
+	layout := keyboardLayout{
+		name: name,
+		chars: map[rune]layoutKey{
+			' ':  {Key: evdev.KEY_SPACE},
+			'\n': {Key: evdev.KEY_ENTER},
+			'\t': {Key: evdev.KEY_TAB},
+		},
+		keycaps: make(map[KeyCode]rune),
+		dead:    dead,
+	}
+	for _, row := range rows {
+		normal := []rune(row.normal)
+		shifted := []rune(row.shifted)
+		altGr := []rune(row.altGr)
+		if len(altGr) == 0 {
+			altGr = []rune(strings.Repeat(" ", len(row.keys)))
+		}
+		if len(normal) != len(row.keys) || len(shifted) != len(row.keys) || len(altGr) != len(row.keys) {
+			panic(fmt.Sprintf("layout %q: invalid row %q", name, row.normal))
+		}
+		for i, key := range row.keys {
+			layout.keycaps[key] = normal[i]
+			for _, k := range []struct {
+				char rune
+				key  layoutKey
+			}{
+				{normal[i], layoutKey{Key: key}},
+				{shifted[i], layoutKey{Key: key, Shift: true}},
+				{altGr[i], layoutKey{Key: key, AltGr: true}},
+			} {
+				if k.char == ' ' {
+					continue
+				}
+				if _, ok := layout.chars[k.char]; ok {
+					panic(fmt.Sprintf("layout %q: character %q is defined twice", name, k.char))
+				}
+				layout.chars[k.char] = k.key
+			}
+		}
+	}
+	return &layout
+}
+
+// typeable returns the key which types the character. Dead keys are not typeable.
+func (l *keyboardLayout) typeable(r rune) (layoutKey, bool) {
+	if strings.ContainsRune(l.dead, r) {
+		return layoutKey{}, false
+	}
+	k, ok := l.chars[r]
+	return k, ok
+}
+
+// keycap returns the character which is printed on the key. Empty, if the key does not type a
+// character (for example shift or F1).
+func (l *keyboardLayout) keycap(key KeyCode) string {
+	if l == nil {
+		return ""
+	}
+	r, ok := l.keycaps[key]
+	if !ok {
+		return ""
+	}
+	return string(r)
+}
+
+// layoutSeparators separate the words in keys and outKeys. They can not be used as keycaps.
+const layoutSeparators = " \t\n,+{}"
+
+// layoutUsage: how translate handles characters which need shift or AltGr.
+type layoutUsage int
+
+const (
+	// layoutInput: keys which get pressed, like 'keys' of a combo. The modifiers get ignored,
+	// "Ö" is the same key as "ö".
+	layoutInput layoutUsage = iota
+
+	// layoutChord: keys which tff writes together, like 'outKeys' or 'hold'. The modifiers get
+	// written, too. For the layout "de", "?" gets "leftshift minus".
+	layoutChord
+
+	// layoutSingleKey: a single key which tff writes, like 'tap' or 'outKey'. Characters which
+	// need modifiers are not allowed.
+	layoutSingleKey
+)
+
+// translate replaces the characters of the keycaps in keys or outKeys by the names of the evdev
+// codes. Example for the layout "de": "ö z" gets "semicolon y". Words with more than one
+// character (like "leftshift" or "20ms") are not changed. "@device" is not changed.
+func (l *keyboardLayout) translate(str string, usage layoutUsage) (string, error) {
+	var b strings.Builder
+	for len(str) > 0 {
+		i := strings.IndexAny(str, layoutSeparators)
+		if i == 0 {
+			b.WriteByte(str[0])
+			str = str[1:]
+			continue
+		}
+		if i == -1 {
+			i = len(str)
+		}
+		word, device, hasDevice := strings.Cut(str[:i], "@")
+		str = str[i:]
+		if utf8.RuneCountInString(word) == 1 {
+			r, _ := utf8.DecodeRuneInString(word)
+			k, ok := l.chars[r]
+			if !ok {
+				return "", fmt.Errorf("character %q is not on the layout %q. Use the name of the key instead. "+
+					"Use sub-command 'print' to see valid names of keys", r, l.name)
+			}
+			switch {
+			case usage == layoutInput || !k.Shift && !k.AltGr:
+				word = strings.ToLower(keyToString(k.Key))
+			case usage == layoutChord:
+				// The keys of a chord can be separated by spaces, in outKeys and in hold.
+				word = strings.ReplaceAll(k.step().String(), "+", " ")
+			default:
+				return "", fmt.Errorf("character %q needs %s on the layout %q. Use 'outText' of a combo instead",
+					r, k.step().String(), l.name)
+			}
+		}
+		b.WriteString(word)
+		if hasDevice {
+			b.WriteString("@" + device)
+		}
+	}
+	return b.String(), nil
+}
+
+// layouts can be used with 'layout' in combos.yaml and with 'print --layout'.
+var layouts = map[string]*keyboardLayout{
+	"us": layoutUS,
+	"de": layoutDE,
+	"fr": layoutFR,
+}
+
+func layoutByName(name string) (*keyboardLayout, error) {
+	if name == "" {
+		return nil, nil
+	}
+	layout, ok := layouts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown layout %q. Valid values: %s", name,
+			strings.Join(slices.Sorted(maps.Keys(layouts)), ", "))
+	}
+	return layout, nil
+}
+
+// applyLayout translates the keycaps in all keys of the yaml file to the names of the evdev
+// codes. This gets done before the keys get parsed.
+func applyLayout(y *Yaml, layout *keyboardLayout) error {
+	if layout == nil {
+		return nil
+	}
+	translate := func(usage layoutUsage, strs ...*string) error {
+		for _, s := range strs {
+			translated, err := layout.translate(*s, usage)
+			if err != nil {
+				return err
+			}
+			*s = translated
+		}
+		return nil
+	}
+	for _, yamlLayer := range append([]*yamlLayer{&y.yamlLayer}, layerPointers(y.Layers)...) {
+		for i := range yamlLayer.Remaps {
+			r := &yamlLayer.Remaps[i]
+			if err := translate(layoutInput, &r.Key); err != nil {
+				return fmt.Errorf("remap %q: %w", r.Key, err)
+			}
+			if err := translate(layoutSingleKey, &r.OutKey); err != nil {
+				return fmt.Errorf("remap %q: %w", r.Key, err)
+			}
+		}
+		for i := range yamlLayer.TapHold {
+			th := &yamlLayer.TapHold[i]
+			if err := translate(layoutInput, &th.Key); err != nil {
+				return fmt.Errorf("tapHold %q: %w", th.Key, err)
+			}
+			if err := translate(layoutSingleKey, &th.Tap); err != nil {
+				return fmt.Errorf("tapHold %q: %w", th.Key, err)
+			}
+			if err := translate(layoutChord, &th.Hold); err != nil {
+				return fmt.Errorf("tapHold %q: %w", th.Key, err)
+			}
+		}
+		for i := range yamlLayer.Combos {
+			c := &yamlLayer.Combos[i]
+			if err := translate(layoutInput, &c.Keys); err != nil {
+				return fmt.Errorf("combo %q: %w", c.Keys, err)
+			}
+			if err := translate(layoutChord, &c.OutKeys); err != nil {
+				return fmt.Errorf("combo %q: %w", c.Keys, err)
+			}
+		}
+		for i := range yamlLayer.Sequences {
+			seq := &yamlLayer.Sequences[i]
+			if err := translate(layoutInput, &seq.Keys); err != nil {
+				return fmt.Errorf("sequence %q: %w", seq.Keys, err)
+			}
+			if err := translate(layoutChord, &seq.OutKeys); err != nil {
+				return fmt.Errorf("sequence %q: %w", seq.Keys, err)
+			}
+		}
+	}
+	for i := range y.Block {
+		if err := translate(layoutInput, &y.Block[i]); err != nil {
+			return fmt.Errorf("'block': %w", err)
+		}
+	}
+	for i := range y.Devices {
+		d := &y.Devices[i]
+		for j := range d.Block {
+			if err := translate(layoutInput, &d.Block[j]); err != nil {
+				return fmt.Errorf("device %q: 'block': %w", d.Name, err)
+			}
+		}
+	}
+	for char, outKeys := range y.Text.Compose {
+		if err := translate(layoutChord, &outKeys); err != nil {
+			return fmt.Errorf("'text: compose': %q: %w", char, err)
+		}
+		y.Text.Compose[char] = outKeys
+	}
+	return nil
+}
+
+func layerPointers(layers []yamlLayer) []*yamlLayer {
+	ret := make([]*yamlLayer, 0, len(layers))
+	for i := range layers {
+		ret = append(ret, &layers[i])
+	}
+	return ret
+}
+
+var numberRowKeys = []KeyCode{
+	evdev.KEY_GRAVE, evdev.KEY_1, evdev.KEY_2, evdev.KEY_3, evdev.KEY_4, evdev.KEY_5,
+	evdev.KEY_6, evdev.KEY_7, evdev.KEY_8, evdev.KEY_9, evdev.KEY_0, evdev.KEY_MINUS,
+	evdev.KEY_EQUAL,
+}
+
+var topRowKeys = []KeyCode{
+	evdev.KEY_Q, evdev.KEY_W, evdev.KEY_E, evdev.KEY_R, evdev.KEY_T, evdev.KEY_Y,
+	evdev.KEY_U, evdev.KEY_I, evdev.KEY_O, evdev.KEY_P, evdev.KEY_LEFTBRACE,
+	evdev.KEY_RIGHTBRACE,
+}
+
+var homeRowKeys = []KeyCode{
+	evdev.KEY_A, evdev.KEY_S, evdev.KEY_D, evdev.KEY_F, evdev.KEY_G, evdev.KEY_H,
+	evdev.KEY_J, evdev.KEY_K, evdev.KEY_L, evdev.KEY_SEMICOLON, evdev.KEY_APOSTROPHE,
+	evdev.KEY_BACKSLASH,
+}
+
+var bottomRowKeys = []KeyCode{
+	evdev.KEY_102ND, evdev.KEY_Z, evdev.KEY_X, evdev.KEY_C, evdev.KEY_V, evdev.KEY_B,
+	evdev.KEY_N, evdev.KEY_M, evdev.KEY_COMMA, evdev.KEY_DOT, evdev.KEY_SLASH,
+}
+
+// layoutUS: backslash is in the top row on ANSI keyboards. The US layout has no key 102nd.
+var layoutUS = newKeyboardLayout("us", "", []layoutRow{
+	{
+		keys:    numberRowKeys,
+		normal:  "`1234567890-=",
+		shifted: "~!@#$%^&*()_+",
+	},
+	{
+		keys:    append(slices.Clone(topRowKeys), evdev.KEY_BACKSLASH),
+		normal:  `qwertyuiop[]\`,
+		shifted: "QWERTYUIOP{}|",
+	},
+	{
+		keys:    homeRowKeys[:len(homeRowKeys)-1],
+		normal:  "asdfghjkl;'",
+		shifted: `ASDFGHJKL:"`,
+	},
+	{
+		keys:    bottomRowKeys[1:],
+		normal:  "zxcvbnm,./",
+		shifted: "ZXCVBNM<>?",
+	},
+})
+
+// layoutDE is the German QWERTZ layout (T1).
+var layoutDE = newKeyboardLayout("de", "^´`", []layoutRow{
+	{
+		keys:    numberRowKeys,
+		normal:  "^1234567890ß´",
+		shifted: "°!\"§$%&/()=?`",
+		altGr:   "  ²³   {[]}\\ ",
+	},
+	{
+		keys:    topRowKeys,
+		normal:  "qwertzuiopü+",
+		shifted: "QWERTZUIOPÜ*",
+		altGr:   "@ €        ~",
+	},
+	{
+		keys:    homeRowKeys,
+		normal:  "asdfghjklöä#",
+		shifted: "ASDFGHJKLÖÄ'",
+	},
+	{
+		keys:    bottomRowKeys,
+		normal:  "<yxcvbnm,.-",
+		shifted: ">YXCVBNM;:_",
+		altGr:   "|      µ   ",
+	},
+})
+
+// layoutFR is the French AZERTY layout.
+var layoutFR = newKeyboardLayout("fr", "^¨~`", []layoutRow{
+	{
+		keys:    numberRowKeys,
+		normal:  "²&é\"'(-è_çà)=",
+		shifted: " 1234567890°+",
+		altGr:   "  ~#{[|`\\ @]}",
+	},
+	{
+		keys:    topRowKeys,
+		normal:  "azertyuiop^$",
+		shifted: "AZERTYUIOP¨£",
+		altGr:   "  €        ¤",
+	},
+	{
+		keys:    homeRowKeys,
+		normal:  "qsdfghjklmù*",
+		shifted: "QSDFGHJKLM%µ",
+	},
+	{
+		keys:    bottomRowKeys,
+		normal:  "<wxcvbn,;:!",
+		shifted: ">WXCVBN?./§",
+	},
+})
@@ -0,0 +1,105 @@
+package tff
+
+import (
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_keyboardLayout_translate(t *testing.T) {
+	for _, tt := range []struct {
+		layout   *keyboardLayout
+		usage    layoutUsage
+		input    string
+		expected string
+	}{
+		{layoutDE, layoutInput, "ö z", "semicolon y"},
+		{layoutDE, layoutInput, "{y ä}", "{z apostrophe}"},
+		{layoutDE, layoutChord, "ctrl+z, 20ms, ß", "ctrl+y, 20ms, minus"},
+		{layoutDE, layoutInput, "ö@laptop leftshift", "semicolon@laptop leftshift"},
+		{layoutDE, layoutInput, "Ö", "semicolon"},
+		{layoutDE, layoutChord, "Ö", "leftshift semicolon"},
+		{layoutDE, layoutChord, "ctrl+?, €", "ctrl+leftshift minus, rightalt e"},
+		{layoutDE, layoutSingleKey, "ß", "minus"},
+		{layoutFR, layoutInput, "a m é", "q semicolon 2"},
+		{layoutUS, layoutInput, "; '", "semicolon apostrophe"},
+	} {
+		t.Run(tt.layout.name+" "+tt.input, func(t *testing.T) {
+			actual, err := tt.layout.translate(tt.input, tt.usage)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+
+	_, err := layoutDE.translate("f ñ", layoutInput)
+	require.ErrorContains(t, err, `character 'ñ' is not on the layout "de".`)
+	_, err = layoutDE.translate("?", layoutSingleKey)
+	require.ErrorContains(t, err, `character '?' needs leftshift+minus on the layout "de". Use 'outText' of a combo instead`)
+}
+
+func Test_Layout_LoadYaml(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+layout: de
+block:
+  - ü
+combos:
+  - keys: ö z
+    outKeys: ctrl+y
+  - keys: f j
+    outText: "@y"
+`))
+	require.NoError(t, err)
+	combos := config.BaseLayer().Combos
+	require.Equal(t, []KeyCode{evdev.KEY_SEMICOLON, evdev.KEY_Y}, combos[0].Keys)
+	require.Equal(t, []KeyCode{evdev.KEY_LEFTCTRL, evdev.KEY_Z}, combos[0].OutKeys)
+	require.Equal(t, []KeyCode{evdev.KEY_LEFTBRACE}, config.Block)
+	require.Equal(t, []OutputStep{
+		{Keys: []KeyCode{evdev.KEY_RIGHTALT, evdev.KEY_Q}},
+		{Keys: []KeyCode{evdev.KEY_Z}},
+	}, combos[1].Output)
+
+	// Shift and AltGr of the characters in outKeys get written.
+	config, err = LoadYamlFromBytes([]byte(`
+layout: de
+combos:
+  - keys: f j
+    outKeys: "?"
+  - keys: f k
+    outKeys: €
+`))
+	require.NoError(t, err)
+	combos = config.BaseLayer().Combos
+	require.Equal(t, []KeyCode{evdev.KEY_LEFTSHIFT, evdev.KEY_MINUS}, combos[0].OutKeys)
+	require.Equal(t, []KeyCode{evdev.KEY_RIGHTALT, evdev.KEY_E}, combos[1].OutKeys)
+
+	_, err = LoadYamlFromBytes([]byte(`
+layout: de
+tapHold:
+  - key: f
+    tap: "?"
+    hold: leftshift
+`))
+	require.ErrorContains(t, err, `tapHold "f": character '?' needs leftshift+minus on the layout "de".`)
+
+	_, err = LoadYamlFromBytes([]byte(`
+layout: de
+combos:
+  - keys: ñ j
+    outKeys: x
+`))
+	require.ErrorContains(t, err, `layout "de": combo "ñ j": character 'ñ' is not on the layout "de".`)
+
+	_, err = LoadYamlFromBytes([]byte("layout: dvorak\n"))
+	require.ErrorContains(t, err, `unknown layout "dvorak". Valid values: de, fr, us`)
+}
+
+func Test_eventToString_Layout(t *testing.T) {
+	ev := Event{Type: evdev.EV_KEY, Code: evdev.KEY_SEMICOLON, Value: DOWN}
+	require.Equal(t, "semicolon_", eventToString(&ev, nil))
+	require.Equal(t, "ö_", eventToString(&ev, layoutDE))
+	ev = Event{Type: evdev.EV_KEY, Code: evdev.KEY_Z, Value: UP}
+	require.Equal(t, "y/", eventToString(&ev, layoutDE))
+	ev = Event{Type: evdev.EV_KEY, Code: evdev.KEY_LEFTCTRL, Value: UP}
+	require.Equal(t, "leftctrl/", eventToString(&ev, layoutDE))
+}
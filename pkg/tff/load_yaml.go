@@ -2,61 +2,738 @@ package tff
 
 import (
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/holoplot/go-evdev"
 	"gopkg.in/yaml.v3"
 )
 
 type Yaml struct {
-	Combos []struct {
-		Keys    string `yaml:"keys"`
-		OutKeys string `yaml:"outKeys"`
-	} `yaml:"combos"`
+	yamlLayer     `yaml:",inline"`
+	Layers        yamlLayers    `yaml:"layers"`
+	Timing        yamlTiming    `yaml:"timing"`
+	OutputPacing  time.Duration `yaml:"outputPacing"`
+	Repeat        yamlRepeat    `yaml:"repeat"`
+	RunDefaults   yamlRun       `yaml:"runDefaults"`
+	Devices       []yamlDevice  `yaml:"devices"`
+	Block         []string      `yaml:"block"`
+	CountBlocked  bool          `yaml:"countBlocked"`
+	ControlSocket string        `yaml:"controlSocket"`
+	ControlGroup  string        `yaml:"controlGroup"`
+	Text          yamlText      `yaml:"text"`
+	Layout        string        `yaml:"layout"`
+	Mouse         yamlMouse     `yaml:"mouse"`
+	MacroFile     string        `yaml:"macroFile"`
+	StatsFile     string        `yaml:"statsFile"`
+	Training      *yamlTraining `yaml:"training"`
 }
 
-func LoadYamlFile(yamlFile string) ([]*Combo, error) {
+type yamlDevice struct {
+	Name  string   `yaml:"name"`
+	Path  string   `yaml:"path"`
+	Block []string `yaml:"block"`
+}
+
+// yamlRun is used for 'run' of a combo, and for the top-level 'runDefaults'.
+type yamlRun struct {
+	Command string            `yaml:"command"`
+	On      string            `yaml:"on"`
+	Timeout time.Duration     `yaml:"timeout"`
+	User    string            `yaml:"user"`
+	Env     map[string]string `yaml:"env"`
+}
+
+type yamlRepeat struct {
+	Delay    time.Duration `yaml:"delay"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type yamlSequence struct {
+	Keys    string        `yaml:"keys"`
+	OutKeys string        `yaml:"outKeys"`
+	OutText string        `yaml:"outText"`
+	Timeout time.Duration `yaml:"timeout"`
+	Pacing  time.Duration `yaml:"pacing"`
+
+	// Line in the yaml file. Set by UnmarshalYAML.
+	Line int `yaml:"-"`
+}
+
+type yamlTiming struct {
+	MinOverlap time.Duration `yaml:"minOverlap"`
+	MinAge     time.Duration `yaml:"minAge"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+type yamlLayers []yamlLayer
+
+// yamlLayer is used for the top-level (base layer) and for each entry in 'layers'.
+type yamlLayer struct {
+	Name      string         `yaml:"name"`
+	Remaps    []yamlRemap    `yaml:"remaps"`
+	TapHold   []yamlTapHold  `yaml:"tapHold"`
+	Combos    []yamlCombo    `yaml:"combos"`
+	Sequences []yamlSequence `yaml:"sequences"`
+
+	// Line in the yaml file. Zero for the top-level.
+	Line int `yaml:"-"`
+}
+
+type yamlRemap struct {
+	Key    string `yaml:"key"`
+	OutKey string `yaml:"outKey"`
+	Action string `yaml:"action"`
+	Mouse  string `yaml:"mouse"`
+
+	// Line in the yaml file. Set by UnmarshalYAML.
+	Line int `yaml:"-"`
+}
+
+type yamlTapHold struct {
+	Key                 string        `yaml:"key"`
+	Tap                 string        `yaml:"tap"`
+	Hold                string        `yaml:"hold"`
+	HoldLayer           string        `yaml:"holdLayer"`
+	TappingTerm         time.Duration `yaml:"tappingTerm"`
+	HoldOnOtherKeyPress bool          `yaml:"holdOnOtherKeyPress"`
+	PermissiveHold      bool          `yaml:"permissiveHold"`
+
+	// Line in the yaml file. Set by UnmarshalYAML.
+	Line int `yaml:"-"`
+}
+
+type yamlCombo struct {
+	Keys      string        `yaml:"keys"`
+	OutKeys   string        `yaml:"outKeys"`
+	OutText   string        `yaml:"outText"`
+	Order     string        `yaml:"order"`
+	Timing    yamlTiming    `yaml:"timing"`
+	Pacing    time.Duration `yaml:"pacing"`
+	Modifiers string        `yaml:"modifiers"`
+	Run       *yamlRun      `yaml:"run"`
+	Mouse     string        `yaml:"mouse"`
+	Macro     string        `yaml:"macro"`
+	Group     string        `yaml:"group"`
+
+	// Line in the yaml file. Set by UnmarshalYAML.
+	Line int `yaml:"-"`
+}
+
+// The UnmarshalYAML methods remember the line of each entry. The line gets used in messages
+// about the entry.
+
+// yamlLayers has no UnmarshalYAML on the element type, because yamlLayer is inlined in Yaml.
+func (y *yamlLayers) UnmarshalYAML(node *yaml.Node) error {
+	var layers []yamlLayer
+	if err := node.Decode(&layers); err != nil {
+		return err
+	}
+	for i := range layers {
+		layers[i].Line = node.Content[i].Line
+	}
+	*y = layers
+	return nil
+}
+
+func (y *yamlRemap) UnmarshalYAML(node *yaml.Node) error {
+	type plain yamlRemap
+	y.Line = node.Line
+	return node.Decode((*plain)(y))
+}
+
+func (y *yamlTapHold) UnmarshalYAML(node *yaml.Node) error {
+	type plain yamlTapHold
+	y.Line = node.Line
+	return node.Decode((*plain)(y))
+}
+
+func (y *yamlCombo) UnmarshalYAML(node *yaml.Node) error {
+	type plain yamlCombo
+	y.Line = node.Line
+	return node.Decode((*plain)(y))
+}
+
+func (y *yamlSequence) UnmarshalYAML(node *yaml.Node) error {
+	type plain yamlSequence
+	y.Line = node.Line
+	return node.Decode((*plain)(y))
+}
+
+func LoadYamlFile(yamlFile string) (*Config, error) {
 	data, err := os.ReadFile(yamlFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read yaml config from %q: %w", yamlFile, err)
 	}
-	combos, err := LoadYamlFromBytes(data)
+	config, err := LoadYamlFromBytes(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse %q: %w", yamlFile, err)
 	}
-	return combos, nil
+	return config, nil
 }
 
-func LoadYamlFromBytes(yamlBytes []byte) ([]*Combo, error) {
+func LoadYamlFromBytes(yamlBytes []byte) (*Config, error) {
 	y := Yaml{}
 	err := yaml.Unmarshal(yamlBytes, &y)
 	if err != nil {
 		return nil, err
 	}
-	combos := make([]*Combo, 0, len(y.Combos))
-	for _, yamlCombo := range y.Combos {
-		combo := Combo{}
-		if len(yamlCombo.Keys) == 0 {
-			return nil, fmt.Errorf("empty list in 'keys' is not allowed.")
+	if y.Name != "" {
+		return nil, fmt.Errorf("'name' is not allowed at the top-level. The top-level is always the layer %q", BaseLayerName)
+	}
+	y.Name = BaseLayerName
+	layout, err := layoutByName(y.Layout)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyLayout(&y, layout); err != nil {
+		return nil, fmt.Errorf("layout %q: %w", y.Layout, err)
+	}
+	timing, err := yamlTimingToTiming(y.Timing)
+	if err != nil {
+		return nil, err
+	}
+	if y.OutputPacing < 0 {
+		return nil, fmt.Errorf("negative 'outputPacing' is not allowed.")
+	}
+	repeat, err := yamlRepeatToRepeat(y.Repeat)
+	if err != nil {
+		return nil, err
+	}
+	block, err := yamlBlockToKeys(y.Block)
+	if err != nil {
+		return nil, err
+	}
+	mouse, err := yamlMouseToMouse(y.Mouse)
+	if err != nil {
+		return nil, err
+	}
+	training, err := yamlTrainingToTraining(y.Training)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Timing:        timing,
+		OutputPacing:  y.OutputPacing,
+		Repeat:        repeat,
+		Block:         block,
+		CountBlocked:  y.CountBlocked,
+		ControlSocket: y.ControlSocket,
+		ControlGroup:  y.ControlGroup,
+		Layout:        layout,
+		Mouse:         mouse,
+		MacroFile:     y.MacroFile,
+		StatsFile:     y.StatsFile,
+		Training:      training,
+	}
+	if config.ControlGroup != "" && config.ControlSocket == "" {
+		return nil, fmt.Errorf("'controlGroup' needs 'controlSocket'.")
+	}
+	for _, yamlLayer := range append([]yamlLayer{y.yamlLayer}, y.Layers...) {
+		if yamlLayer.Name == "" {
+			return nil, fmt.Errorf("layer without 'name' is not allowed.")
+		}
+		if other := config.LayerByName(yamlLayer.Name); other != nil {
+			return nil, fmt.Errorf("layer %q is defined twice (lines %d and %d).", yamlLayer.Name,
+				other.Line, yamlLayer.Line)
+		}
+		layer, err := yamlLayerToLayer(yamlLayer)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %w", yamlLayer.Name, err)
+		}
+		config.Layers = append(config.Layers, layer)
+	}
+
+	if err := applyRunDefaults(config, y.RunDefaults); err != nil {
+		return nil, err
+	}
+	if err := applyText(config, y.Text); err != nil {
+		return nil, err
+	}
+	if err := checkDevices(config, y.Devices); err != nil {
+		return nil, err
+	}
+
+	// Check that all layer actions point to existing layers.
+	for _, layer := range config.Layers {
+		for _, remap := range layer.Remaps {
+			if remap.Action != nil && config.LayerByName(remap.Action.Layer) == nil {
+				return nil, fmt.Errorf("layer %q: action %q of key %q: unknown layer %q",
+					layer.Name, remap.Action.Type, keyToString(remap.Key), remap.Action.Layer)
+			}
+			if remap.TapHold != nil && remap.TapHold.HoldLayer != "" &&
+				config.LayerByName(remap.TapHold.HoldLayer) == nil {
+				return nil, fmt.Errorf("layer %q: tapHold of key %q: unknown layer %q",
+					layer.Name, keyToString(remap.Key), remap.TapHold.HoldLayer)
+			}
+		}
+	}
+	config.Findings = checkConfig(config)
+	return config, nil
+}
+
+func yamlLayerToLayer(yamlLayer yamlLayer) (*Layer, error) {
+	layer := Layer{
+		Name:   yamlLayer.Name,
+		Line:   yamlLayer.Line,
+		Remaps: make(map[KeyCode]*Remap, len(yamlLayer.Remaps)),
+		Combos: make([]*Combo, 0, len(yamlLayer.Combos)),
+	}
+	for _, yamlRemap := range yamlLayer.Remaps {
+		remap, err := yamlRemapToRemap(yamlRemap)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := layer.Remaps[remap.Key]; ok {
+			return nil, fmt.Errorf("key %q is remapped twice (lines %d and %d).", yamlRemap.Key,
+				other.Line, remap.Line)
+		}
+		layer.Remaps[remap.Key] = remap
+	}
+	for _, yamlTapHold := range yamlLayer.TapHold {
+		tapHold, err := yamlTapHoldToTapHold(yamlTapHold)
+		if err != nil {
+			return nil, err
 		}
-		keys, err := stringToKeyCodes(yamlCombo.Keys)
+		if other, ok := layer.Remaps[tapHold.Key]; ok {
+			if other.TapHold != nil {
+				return nil, fmt.Errorf("key %q is used in 'tapHold' twice (lines %d and %d).",
+					yamlTapHold.Key, other.Line, yamlTapHold.Line)
+			}
+			return nil, fmt.Errorf("key %q is used in 'remaps' and 'tapHold' (lines %d and %d).",
+				yamlTapHold.Key, other.Line, yamlTapHold.Line)
+		}
+		layer.Remaps[tapHold.Key] = &Remap{
+			Key:     tapHold.Key,
+			TapHold: tapHold,
+			Line:    yamlTapHold.Line,
+		}
+	}
+	for _, yamlCombo := range yamlLayer.Combos {
+		combo, err := yamlComboToCombo(yamlCombo)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range layer.Combos {
+			if !combosOverlap(combo, other) || !modifiersEqual(combo.Modifiers, other.Modifiers) ||
+				!keyDevicesOverlap(combo, other) {
+				continue
+			}
+			if other.keysString() == combo.keysString() {
+				return nil, fmt.Errorf("combo %q is defined twice (lines %d and %d).",
+					combo.keysString(), other.Line, combo.Line)
+			}
+			return nil, fmt.Errorf("combos %q and %q contradict each other: "+
+				"there is an order of pressing the keys which matches both (lines %d and %d).",
+				other.keysString(), combo.keysString(), other.Line, combo.Line)
+		}
+		layer.Combos = append(layer.Combos, combo)
+	}
+	for _, yamlSequence := range yamlLayer.Sequences {
+		seq, err := yamlSequenceToSequence(yamlSequence)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range layer.Sequences {
+			if slices.Equal(seq.Keys, other.Keys) {
+				return nil, fmt.Errorf("sequence %q is defined twice (lines %d and %d).",
+					yamlSequence.Keys, other.Line, seq.Line)
+			}
+		}
+		layer.Sequences = append(layer.Sequences, seq)
+	}
+	layer.buildComboIndex()
+	return &layer, nil
+}
+
+func yamlRemapToRemap(yamlRemap yamlRemap) (*Remap, error) {
+	if yamlRemap.Key == "" {
+		return nil, fmt.Errorf("empty 'key' in remap is not allowed.")
+	}
+	key, err := wordToKeyCode(yamlRemap.Key)
+	if err != nil {
+		return nil, err
+	}
+	remap := Remap{Key: key, Line: yamlRemap.Line}
+	switch {
+	case yamlRemap.OutKey != "" && yamlRemap.Action != "":
+		return nil, fmt.Errorf("remap of %q: 'outKey' and 'action' are mutually exclusive.", yamlRemap.Key)
+	case yamlRemap.Mouse != "" && (yamlRemap.OutKey != "" || yamlRemap.Action != ""):
+		return nil, fmt.Errorf("remap of %q: 'mouse' can not be used with 'outKey' or 'action'.", yamlRemap.Key)
+	case yamlRemap.Mouse != "":
+		remap.Mouse, err = stringToMouseAction(yamlRemap.Mouse)
+		if err != nil {
+			return nil, fmt.Errorf("remap of %q: %w", yamlRemap.Key, err)
+		}
+	case yamlRemap.OutKey != "":
+		remap.OutKey, err = wordToKeyCode(yamlRemap.OutKey)
 		if err != nil {
 			return nil, err
 		}
-		combo.Keys = keys
+		if err := noScanKeys(remap.OutKey); err != nil {
+			return nil, fmt.Errorf("remap of %q: 'outKey': %w", yamlRemap.Key, err)
+		}
+	case yamlRemap.Action != "":
+		remap.Action, err = stringToLayerAction(yamlRemap.Action)
+		if err != nil {
+			return nil, fmt.Errorf("remap of %q: %w", yamlRemap.Key, err)
+		}
+	default:
+		return nil, fmt.Errorf("remap of %q: 'outKey', 'action' or 'mouse' is needed.", yamlRemap.Key)
+	}
+	return &remap, nil
+}
 
-		if len(yamlCombo.OutKeys) == 0 {
+// stringToLayerAction parses a string like "layer-hold nav".
+func stringToLayerAction(str string) (*LayerAction, error) {
+	words := strings.Fields(str)
+	if len(words) != 2 {
+		return nil, fmt.Errorf("invalid action %q. Expected something like 'layer-hold mylayer'", str)
+	}
+	actionType := LayerActionType(words[0])
+	switch actionType {
+	case LayerHold, LayerToggle, LayerLock:
+	default:
+		return nil, fmt.Errorf("unknown action %q. Valid actions: %s, %s, %s", words[0],
+			LayerHold, LayerToggle, LayerLock)
+	}
+	return &LayerAction{
+		Type:  actionType,
+		Layer: words[1],
+	}, nil
+}
+
+func yamlTapHoldToTapHold(yamlTapHold yamlTapHold) (*TapHold, error) {
+	if yamlTapHold.Key == "" {
+		return nil, fmt.Errorf("empty 'key' in tapHold is not allowed.")
+	}
+	key, err := wordToKeyCode(yamlTapHold.Key)
+	if err != nil {
+		return nil, err
+	}
+	tapHold := TapHold{
+		Key:                 key,
+		Tap:                 key,
+		HoldLayer:           yamlTapHold.HoldLayer,
+		TappingTerm:         yamlTapHold.TappingTerm,
+		HoldOnOtherKeyPress: yamlTapHold.HoldOnOtherKeyPress,
+		PermissiveHold:      yamlTapHold.PermissiveHold,
+	}
+	if yamlTapHold.Tap != "" {
+		tapHold.Tap, err = wordToKeyCode(yamlTapHold.Tap)
+		if err != nil {
+			return nil, err
+		}
+		if err := noScanKeys(tapHold.Tap); err != nil {
+			return nil, fmt.Errorf("tapHold of %q: 'tap': %w", yamlTapHold.Key, err)
+		}
+	}
+	switch {
+	case yamlTapHold.Hold != "" && yamlTapHold.HoldLayer != "":
+		return nil, fmt.Errorf("tapHold of %q: 'hold' and 'holdLayer' are mutually exclusive.", yamlTapHold.Key)
+	case yamlTapHold.Hold != "":
+		tapHold.HoldKeys, err = stringToKeyCodes(yamlTapHold.Hold)
+		if err != nil {
+			return nil, err
+		}
+		if err := noScanKeys(tapHold.HoldKeys...); err != nil {
+			return nil, fmt.Errorf("tapHold of %q: 'hold': %w", yamlTapHold.Key, err)
+		}
+	case yamlTapHold.HoldLayer == "":
+		return nil, fmt.Errorf("tapHold of %q: 'hold' or 'holdLayer' is needed.", yamlTapHold.Key)
+	}
+	if tapHold.TappingTerm < 0 {
+		return nil, fmt.Errorf("tapHold of %q: negative 'tappingTerm' is not allowed.", yamlTapHold.Key)
+	}
+	if tapHold.TappingTerm == 0 {
+		tapHold.TappingTerm = DefaultTappingTerm
+	}
+	return &tapHold, nil
+}
+
+func yamlComboToCombo(yamlCombo yamlCombo) (*Combo, error) {
+	combo := Combo{Line: yamlCombo.Line, Group: yamlCombo.Group}
+	if len(yamlCombo.Keys) == 0 {
+		return nil, fmt.Errorf("empty list in 'keys' is not allowed.")
+	}
+	keysString, wordDevices := splitKeyDevices(yamlCombo.Keys)
+	keys, keyGroups, err := stringToKeyGroups(keysString)
+	if err != nil {
+		return nil, err
+	}
+	combo.Keys = keys
+	for word, device := range wordDevices {
+		key, err := wordToKeyCode(word)
+		if err != nil {
+			return nil, err
+		}
+		if device == "" {
+			return nil, fmt.Errorf("combo %q: empty device name after %q.", yamlCombo.Keys, word+"@")
+		}
+		if combo.KeyDevices == nil {
+			combo.KeyDevices = make(map[KeyCode]string, len(wordDevices))
+		}
+		combo.KeyDevices[key] = device
+	}
+	switch yamlCombo.Order {
+	case "":
+		combo.KeyGroups = keyGroups
+	case OrderStrict, OrderAny:
+		if keyGroups != nil {
+			return nil, fmt.Errorf("combo %q: 'order: %s' contradicts the curly braces in 'keys'.",
+				yamlCombo.Keys, yamlCombo.Order)
+		}
+		if yamlCombo.Order == OrderAny && len(keys) > 1 {
+			combo.KeyGroups = [][]KeyCode{keys}
+		}
+	default:
+		return nil, fmt.Errorf("combo %q: invalid 'order: %s'. Valid values: %s, %s",
+			yamlCombo.Keys, yamlCombo.Order, OrderStrict, OrderAny)
+	}
+
+	if yamlCombo.Run != nil {
+		combo.Run, err = yamlRunToRunAction(*yamlCombo.Run)
+		if err != nil {
+			return nil, fmt.Errorf("combo %q: %w", yamlCombo.Keys, err)
+		}
+	}
+	if yamlCombo.Mouse != "" {
+		combo.Mouse, err = stringToMouseAction(yamlCombo.Mouse)
+		if err != nil {
+			return nil, fmt.Errorf("combo %q: %w", yamlCombo.Keys, err)
+		}
+	}
+	if yamlCombo.Macro != "" {
+		combo.Macro, err = stringToMacroAction(yamlCombo.Macro)
+		if err != nil {
+			return nil, fmt.Errorf("combo %q: %w", yamlCombo.Keys, err)
+		}
+	}
+	switch {
+	case yamlCombo.OutKeys != "" && yamlCombo.OutText != "":
+		return nil, fmt.Errorf("combo %q: 'outKeys' and 'outText' are mutually exclusive.", yamlCombo.Keys)
+	case yamlCombo.OutText != "":
+		// The output steps get set by applyText.
+		combo.Text = yamlCombo.OutText
+	case len(yamlCombo.OutKeys) == 0:
+		if combo.Run == nil && combo.Mouse == nil && combo.Macro == nil {
 			return nil, fmt.Errorf("empty list in 'outKeys' is not allowed.")
 		}
-		keys, err = stringToKeyCodes(yamlCombo.OutKeys)
+	default:
+		combo.OutKeys, combo.Output, err = stringToOutput(yamlCombo.OutKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if yamlCombo.Pacing < 0 {
+		return nil, fmt.Errorf("combo %q: negative 'pacing' is not allowed.", yamlCombo.Keys)
+	}
+	combo.Pacing = yamlCombo.Pacing
+
+	combo.Modifiers, err = stringToModifiers(yamlCombo.Modifiers)
+	if err != nil {
+		return nil, fmt.Errorf("combo %q: %w", yamlCombo.Keys, err)
+	}
+	for _, keys := range combo.Modifiers {
+		for _, key := range keys {
+			if slices.Contains(combo.Keys, key) {
+				return nil, fmt.Errorf("combo %q: modifier %q is part of 'keys'.",
+					yamlCombo.Keys, strings.ToLower(keyToString(key)))
+			}
+		}
+	}
+
+	combo.Timing, err = yamlTimingToTiming(yamlCombo.Timing)
+	if err != nil {
+		return nil, fmt.Errorf("combo %q: %w", yamlCombo.Keys, err)
+	}
+	return &combo, nil
+}
+
+func yamlSequenceToSequence(yamlSequence yamlSequence) (*Sequence, error) {
+	keys, err := stringToKeyCodes(yamlSequence.Keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) < 2 {
+		return nil, fmt.Errorf("sequence %q: at least two keys are needed.", yamlSequence.Keys)
+	}
+	var outKeys []KeyCode
+	var output []OutputStep
+	switch {
+	case yamlSequence.OutKeys != "" && yamlSequence.OutText != "":
+		return nil, fmt.Errorf("sequence %q: 'outKeys' and 'outText' are mutually exclusive.", yamlSequence.Keys)
+	case yamlSequence.OutText != "":
+		// The output steps get set by applyText.
+	case len(yamlSequence.OutKeys) == 0:
+		return nil, fmt.Errorf("sequence %q: empty list in 'outKeys' is not allowed.", yamlSequence.Keys)
+	default:
+		outKeys, output, err = stringToOutput(yamlSequence.OutKeys)
 		if err != nil {
 			return nil, err
 		}
-		combo.OutKeys = keys
-		combos = append(combos, &combo)
 	}
-	return combos, nil
+	if yamlSequence.Pacing < 0 {
+		return nil, fmt.Errorf("sequence %q: negative 'pacing' is not allowed.", yamlSequence.Keys)
+	}
+	if yamlSequence.Timeout < 0 {
+		return nil, fmt.Errorf("sequence %q: negative 'timeout' is not allowed.", yamlSequence.Keys)
+	}
+	seq := Sequence{
+		Combo: Combo{
+			Keys:    keys,
+			OutKeys: outKeys,
+			Output:  output,
+			Text:    yamlSequence.OutText,
+			Pacing:  yamlSequence.Pacing,
+			Line:    yamlSequence.Line,
+		},
+		Timeout: yamlSequence.Timeout,
+	}
+	if seq.Timeout == 0 {
+		seq.Timeout = DefaultSequenceTimeout
+	}
+	return &seq, nil
+}
+
+// checkDevices sets the devices of the config, and checks that combos use only known devices.
+func checkDevices(config *Config, yamlDevices []yamlDevice) error {
+	for _, yamlDevice := range yamlDevices {
+		if yamlDevice.Name == "" || yamlDevice.Path == "" {
+			return fmt.Errorf("each entry in 'devices' needs a 'name' and a 'path'.")
+		}
+		if config.deviceByName(yamlDevice.Name) != nil {
+			return fmt.Errorf("device %q is defined twice.", yamlDevice.Name)
+		}
+		block, err := yamlBlockToKeys(yamlDevice.Block)
+		if err != nil {
+			return fmt.Errorf("device %q: %w", yamlDevice.Name, err)
+		}
+		config.Devices = append(config.Devices, DeviceConfig{
+			Name:  yamlDevice.Name,
+			Path:  yamlDevice.Path,
+			Block: block,
+		})
+	}
+	for _, layer := range config.Layers {
+		for _, combo := range layer.Combos {
+			for _, device := range combo.KeyDevices {
+				if config.deviceByName(device) == nil {
+					return fmt.Errorf("layer %q: combo %q: unknown device %q. Add it to 'devices'.",
+						layer.Name, combo.keysString(), device)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// yamlBlockToKeys converts the key names of 'block'.
+func yamlBlockToKeys(words []string) ([]KeyCode, error) {
+	keys := make([]KeyCode, 0, len(words))
+	for _, word := range words {
+		key, err := wordToKeyCode(word)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in 'block': %w", err)
+		}
+		if slices.Contains(keys, key) {
+			return nil, fmt.Errorf("key %q is in 'block' twice.", word)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func yamlRunToRunAction(yamlRun yamlRun) (*RunAction, error) {
+	if yamlRun.Command == "" {
+		return nil, fmt.Errorf("'run' needs a 'command'.")
+	}
+	action := RunAction{
+		Command: yamlRun.Command,
+		On:      yamlRun.On,
+		Timeout: yamlRun.Timeout,
+		User:    yamlRun.User,
+		Env:     yamlRun.Env,
+	}
+	switch action.On {
+	case "":
+		action.On = RunOnDown
+	case RunOnDown, RunOnUp:
+	default:
+		return nil, fmt.Errorf("invalid 'on: %s' in 'run'. Valid values: %s, %s", action.On, RunOnDown, RunOnUp)
+	}
+	if action.Timeout < 0 {
+		return nil, fmt.Errorf("negative 'timeout' in 'run' is not allowed.")
+	}
+	return &action, nil
+}
+
+// applyRunDefaults sets the values of 'runDefaults' for all run actions which do not set them.
+func applyRunDefaults(config *Config, defaults yamlRun) error {
+	if defaults.Command != "" || defaults.On != "" {
+		return fmt.Errorf("'runDefaults' supports only 'user', 'env' and 'timeout'.")
+	}
+	if defaults.Timeout < 0 {
+		return fmt.Errorf("negative 'timeout' in 'runDefaults' is not allowed.")
+	}
+	if defaults.Timeout == 0 {
+		defaults.Timeout = DefaultRunTimeout
+	}
+	var actions []*RunAction
+	for _, layer := range config.Layers {
+		for _, combo := range layer.Combos {
+			if combo.Run != nil {
+				actions = append(actions, combo.Run)
+			}
+		}
+	}
+	if config.Training != nil && config.Training.Hook != nil {
+		actions = append(actions, config.Training.Hook)
+	}
+	for _, action := range actions {
+		if action.Timeout == 0 {
+			action.Timeout = defaults.Timeout
+		}
+		if action.User == "" {
+			action.User = defaults.User
+		}
+		env := maps.Clone(defaults.Env)
+		if env == nil {
+			env = make(map[string]string, len(action.Env))
+		}
+		maps.Copy(env, action.Env)
+		action.Env = env
+	}
+	return nil
+}
+
+func yamlRepeatToRepeat(yamlRepeat yamlRepeat) (Repeat, error) {
+	repeat := Repeat{
+		Delay:    yamlRepeat.Delay,
+		Interval: yamlRepeat.Interval,
+	}
+	if repeat.Delay < 0 || repeat.Interval < 0 {
+		return Repeat{}, fmt.Errorf("negative values in 'repeat' are not allowed.")
+	}
+	if (repeat.Delay == 0) != (repeat.Interval == 0) {
+		return Repeat{}, fmt.Errorf("'repeat' needs 'delay' and 'interval'.")
+	}
+	return repeat, nil
+}
+
+func yamlTimingToTiming(yamlTiming yamlTiming) (Timing, error) {
+	timing := Timing{
+		MinOverlap: yamlTiming.MinOverlap,
+		MinAge:     yamlTiming.MinAge,
+		Timeout:    yamlTiming.Timeout,
+	}
+	if timing.MinOverlap < 0 || timing.MinAge < 0 || timing.Timeout < 0 {
+		return timing, fmt.Errorf("negative durations in 'timing' are not allowed: %s", timing.String())
+	}
+	return timing, nil
 }
 
 func stringToKeyCodes(str string) ([]KeyCode, error) {
@@ -80,10 +757,16 @@ var (
 )
 
 func wordToKeyCode(s string) (KeyCode, error) {
+	if strings.HasPrefix(s, scanKeyPrefix) {
+		return wordToScanKey(s)
+	}
 	if strings.ToLower(s) != s {
 		return 0, fmt.Errorf("key %q is invalid: %w", s, OnlyLowerCaseAllowedErr)
 	}
 	keyString := fmt.Sprintf("KEY_%s", string(strings.ToUpper(s)))
+	if strings.HasPrefix(s, buttonPrefix) {
+		keyString = strings.ToUpper(s)
+	}
 	key, ok := evdev.KEYFromString[keyString]
 	if !ok {
 		return 0, fmt.Errorf("failed to get key %q: %w. Use sub-command 'print' to see valid names of keys", s, UnknownKeyErr)
@@ -49,11 +49,12 @@ func TestLoadYamlFromBytes_ok(t *testing.T) {
 				evdev.KEY_B,
 				evdev.KEY_C,
 			},
+			Line: 2,
 		},
 	}
 	actual, err := LoadYamlFromBytes([]byte(yamlString))
 	require.Nil(t, err)
-	require.Equal(t, expected, actual)
+	require.Equal(t, expected, actual.BaseLayer().Combos)
 }
 
 func TestLoadYamlFromBytes_fail(t *testing.T) {
@@ -88,6 +89,184 @@ func TestLoadYamlFromBytes_fail(t *testing.T) {
 `,
 			`failed to get key "key_not_existing"`,
 		},
+		{
+			`remaps:
+  - key: capslock
+    action: layer-hold nav
+`,
+			`unknown layer "nav"`,
+		},
+		{
+			`remaps:
+  - key: capslock
+    action: layer-jump nav
+layers:
+  - name: nav
+`,
+			`unknown action "layer-jump"`,
+		},
+		{
+			`remaps:
+  - key: capslock
+    outKey: esc
+    action: layer-hold nav
+layers:
+  - name: nav
+`,
+			`'outKey' and 'action' are mutually exclusive.`,
+		},
+		{
+			`layers:
+  - name: nav
+  - name: nav
+`,
+			`layer "nav" is defined twice (lines 2 and 3).`,
+		},
+		{
+			`tapHold:
+  - key: f
+`,
+			`tapHold of "f": 'hold' or 'holdLayer' is needed.`,
+		},
+		{
+			`remaps:
+  - key: f
+    outKey: x
+tapHold:
+  - key: f
+    hold: leftctrl
+`,
+			`key "f" is used in 'remaps' and 'tapHold' (lines 2 and 5).`,
+		},
+		{
+			`combos:
+  - keys: f j
+    outKeys: x
+    timing:
+      timeout: -10ms
+`,
+			`combo "f j": negative durations in 'timing' are not allowed`,
+		},
+		{
+			`combos:
+  - keys: f {j k}
+    outKeys: x
+    order: any
+`,
+			`'order: any' contradicts the curly braces in 'keys'.`,
+		},
+		{
+			`combos:
+  - keys: f j k
+    outKeys: x
+  - keys: f {k j}
+    outKeys: y
+`,
+			`combos "KEY_F KEY_J KEY_K" and "KEY_F {KEY_K KEY_J}" contradict each other`,
+		},
+		{
+			`combos:
+  - keys: f j
+    outKeys: x
+  - keys: a b
+    outKeys: y
+  - keys: f j
+    outKeys: z
+`,
+			`combo "KEY_F KEY_J" is defined twice (lines 2 and 6).`,
+		},
+		{
+			`sequences:
+  - keys: capslock x
+    outKeys: x
+  - keys: capslock x
+    outKeys: y
+`,
+			`sequence "capslock x" is defined twice (lines 2 and 4).`,
+		},
+		{
+			`sequences:
+  - keys: capslock
+    outKeys: x
+`,
+			`sequence "capslock": at least two keys are needed.`,
+		},
+		{
+			`combos:
+  - keys: f j
+    outKeys: ctrl+a,,delete
+`,
+			`empty step in "ctrl+a,,delete"`,
+		},
+		{
+			`combos:
+  - keys: f j
+    outKeys: x
+    pacing: -5ms
+`,
+			`combo "f j": negative 'pacing' is not allowed.`,
+		},
+		{
+			`repeat:
+  delay: 200ms
+combos:
+  - keys: f j
+    outKeys: x
+`,
+			`'repeat' needs 'delay' and 'interval'.`,
+		},
+		{
+			`combos:
+  - keys: f j
+    outKeys: x
+    modifiers: hyper
+`,
+			`combo "f j": unknown modifier "hyper"`,
+		},
+		{
+			`combos:
+  - keys: leftshift j
+    outKeys: x
+    modifiers: shift
+`,
+			`combo "leftshift j": modifier "leftshift" is part of 'keys'.`,
+		},
+		{
+			`combos:
+  - keys: f j
+    run:
+      command: xterm
+      on: hold
+`,
+			`combo "f j": invalid 'on: hold' in 'run'. Valid values: down, up`,
+		},
+		{
+			`combos:
+  - keys: f j
+    run:
+      on: up
+`,
+			`combo "f j": 'run' needs a 'command'.`,
+		},
+		{
+			`combos:
+  - keys: f@pedal j
+    outKeys: x
+`,
+			`layer "base": combo "KEY_F@pedal KEY_J": unknown device "pedal". Add it to 'devices'.`,
+		},
+		{
+			`devices:
+  - name: pedal
+    path: /dev/input/event3
+  - name: pedal
+    path: /dev/input/event4
+combos:
+  - keys: f j
+    outKeys: x
+`,
+			`device "pedal" is defined twice.`,
+		},
 	}
 	for _, tt := range tests {
 		_, err := LoadYamlFromBytes([]byte(tt.yamlString))
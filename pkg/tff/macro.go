@@ -0,0 +1,296 @@
+package tff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/holoplot/go-evdev"
+)
+
+type MacroActionType string
+
+const (
+	// MacroRecord starts recording into a register.
+	MacroRecord MacroActionType = "record"
+
+	// MacroStop stops recording.
+	MacroStop MacroActionType = "stop"
+
+	// MacroPlay writes the events of a register.
+	MacroPlay MacroActionType = "play"
+)
+
+// MacroAction is the 'macro' of a combo. Examples: "record a", "stop", "play a".
+type MacroAction struct {
+	Type MacroActionType
+
+	// Register is the name of the macro. Empty for MacroStop.
+	Register string
+}
+
+func (a MacroAction) String() string {
+	if a.Register == "" {
+		return string(a.Type)
+	}
+	return fmt.Sprintf("%s %s", a.Type, a.Register)
+}
+
+func stringToMacroAction(str string) (*MacroAction, error) {
+	words := strings.Fields(str)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty macro action. Expected something like 'record a', 'stop' or 'play a'")
+	}
+	action := MacroAction{Type: MacroActionType(words[0])}
+	switch action.Type {
+	case MacroStop:
+		if len(words) != 1 {
+			return nil, fmt.Errorf("invalid macro action %q. 'stop' has no register", str)
+		}
+	case MacroRecord, MacroPlay:
+		if len(words) != 2 {
+			return nil, fmt.Errorf("invalid macro action %q. Expected the name of the register, like '%s a'",
+				str, action.Type)
+		}
+		action.Register = words[1]
+	default:
+		return nil, fmt.Errorf("unknown macro action %q. Valid actions: %s, %s, %s", words[0],
+			MacroRecord, MacroStop, MacroPlay)
+	}
+	return &action, nil
+}
+
+// macroRecorder wraps the EventWriter of the state. It records the key events which the combo
+// engine writes. Playback writes to the wrapped EventWriter, so that it does not get recorded.
+type macroRecorder struct {
+	ew EventWriter
+
+	// register is the name of the register which gets recorded. Empty, if not recording.
+	register string
+	recorded []Event
+}
+
+var _ EventWriter = &macroRecorder{}
+
+func newMacroRecorder(ew EventWriter) *macroRecorder {
+	return &macroRecorder{ew: ew}
+}
+
+// macroRegistry contains the registers of all engines of the process. Each device has its own
+// engine, but they share the registers and the macroFile.
+var macroRegistry = struct {
+	sync.Mutex
+	path      string
+	loaded    bool
+	registers map[string][]Event
+
+	// loadErr: the macroFile could not be loaded. It does not get overwritten, so the
+	// registers of the file do not get lost.
+	loadErr error
+}{}
+
+// loadMacroRegistry loads the macroFile, if it is not loaded yet. An empty path means: the
+// registers are not saved.
+func loadMacroRegistry(path string) error {
+	macroRegistry.Lock()
+	defer macroRegistry.Unlock()
+	return loadMacroRegistryLocked(path)
+}
+
+func loadMacroRegistryLocked(path string) error {
+	if macroRegistry.loaded && macroRegistry.path == path {
+		return macroRegistry.loadErr
+	}
+	registers := make(map[string][]Event)
+	var err error
+	if path != "" {
+		registers, err = loadMacros(path)
+	}
+	macroRegistry.path = path
+	macroRegistry.loaded = true
+	macroRegistry.registers = registers
+	macroRegistry.loadErr = err
+	return err
+}
+
+// macroRegister returns the events of the register. The macroFile gets loaded, if it changed
+// (for example by a reload of the config).
+func macroRegister(path, register string) ([]Event, bool) {
+	macroRegistry.Lock()
+	defer macroRegistry.Unlock()
+	if err := loadMacroRegistryLocked(path); err != nil {
+		fmt.Printf("  macro: %s\n", err.Error())
+	}
+	events, ok := macroRegistry.registers[register]
+	return events, ok
+}
+
+// storeMacro sets the events of the register, and saves all registers to the macroFile. If the
+// macroFile could not be loaded, nothing changes.
+func storeMacro(path, register string, events []Event) error {
+	macroRegistry.Lock()
+	defer macroRegistry.Unlock()
+	if err := loadMacroRegistryLocked(path); err != nil {
+		return err
+	}
+	macroRegistry.registers[register] = events
+	if path == "" {
+		return nil
+	}
+	return saveMacros(path, macroRegistry.registers)
+}
+
+func (r *macroRecorder) WriteOne(ev *Event) error {
+	if r.register != "" && ev.Type == evdev.EV_KEY {
+		r.recorded = append(r.recorded, Event{Type: ev.Type, Code: ev.Code, Value: ev.Value})
+	}
+	return r.ew.WriteOne(ev)
+}
+
+// handleMacroAction handles a combo with a macro action. Only the down-event of the combo
+// triggers the action.
+func (state *State) handleMacroAction(action *MacroAction, value upDownValue, t syscall.Timeval) error {
+	if value != DOWN {
+		return nil
+	}
+	r := state.macros
+	switch action.Type {
+	case MacroRecord:
+		if r.register != "" {
+			state.stopMacroRecording()
+		}
+		fmt.Printf("  macro: recording %q\n", action.Register)
+		r.register = action.Register
+		r.recorded = nil
+	case MacroStop:
+		if r.register == "" {
+			fmt.Printf("  macro: stop, but not recording\n")
+			return nil
+		}
+		state.stopMacroRecording()
+	case MacroPlay:
+		return state.playMacro(action.Register, t)
+	}
+	return nil
+}
+
+func (state *State) stopMacroRecording() {
+	r := state.macros
+	events := balancedMacro(r.recorded)
+	fmt.Printf("  macro: recorded %d events into %q\n", len(events), r.register)
+	if err := storeMacro(state.config.MacroFile, r.register, events); err != nil {
+		fmt.Printf("  macro: %s\n", err.Error())
+	}
+	r.register = ""
+	r.recorded = nil
+}
+
+// balancedMacro removes up-events of keys which were pressed before the recording started, and
+// adds up-events for keys which are still pressed when the recording stops.
+func balancedMacro(events []Event) []Event {
+	var balanced []Event
+	down := make(map[KeyCode]bool)
+	var order []KeyCode
+	for _, ev := range events {
+		switch ev.Value {
+		case DOWN:
+			if !down[ev.Code] {
+				order = append(order, ev.Code)
+			}
+			down[ev.Code] = true
+		case UP:
+			if !down[ev.Code] {
+				continue
+			}
+			delete(down, ev.Code)
+		case REPEAT:
+			if !down[ev.Code] {
+				continue
+			}
+		}
+		balanced = append(balanced, ev)
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		if down[order[i]] {
+			balanced = append(balanced, Event{Type: evdev.EV_KEY, Code: order[i], Value: UP})
+			delete(down, order[i])
+		}
+	}
+	return balanced
+}
+
+// playMacro writes the events of the register. The events bypass the recorder and the combo
+// engine. Pacing does not block the engine: the events get queued like the output of combos.
+func (state *State) playMacro(register string, t syscall.Timeval) error {
+	events, ok := macroRegister(state.config.MacroFile, register)
+	if !ok {
+		fmt.Printf("  macro: register %q is empty\n", register)
+		return nil
+	}
+	fmt.Printf("  macro: playing %q (%d events)\n", register, len(events))
+	at := state.outputTime(syscallTimevalToTime(t))
+	for _, ev := range events {
+		at = at.Add(state.config.OutputPacing)
+		ev.Time = t
+		if err := state.queueOrWrite(state.macros.ew, ev, at, "Macro>play"); err != nil {
+			return fmt.Errorf("failed to play macro %q: %w", register, err)
+		}
+	}
+	return nil
+}
+
+// macroFileEvent is an event in the macroFile.
+type macroFileEvent struct {
+	Key   string `json:"key"`
+	Value int32  `json:"value"`
+}
+
+func saveMacros(path string, registers map[string][]Event) error {
+	file := make(map[string][]macroFileEvent, len(registers))
+	for register, events := range registers {
+		file[register] = Map(events, func(ev Event) macroFileEvent {
+			return macroFileEvent{Key: keyCodeName(ev.Code), Value: ev.Value}
+		})
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save macros: %w", err)
+	}
+	return nil
+}
+
+// loadMacros reads the macroFile. A missing file is not an error.
+func loadMacros(path string) (map[string][]Event, error) {
+	registers := make(map[string][]Event)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registers, nil
+	}
+	if err != nil {
+		return registers, fmt.Errorf("failed to load macros: %w", err)
+	}
+	var file map[string][]macroFileEvent
+	if err := json.Unmarshal(data, &file); err != nil {
+		return registers, fmt.Errorf("failed to load macros from %q: %w", path, err)
+	}
+	for register, fileEvents := range file {
+		events := make([]Event, 0, len(fileEvents))
+		for _, fe := range fileEvents {
+			code, ok := evdev.KEYFromString[fe.Key]
+			if !ok {
+				return registers, fmt.Errorf("failed to load macros from %q: unknown key %q in register %q",
+					path, fe.Key, register)
+			}
+			events = append(events, Event{Type: evdev.EV_KEY, Code: code, Value: fe.Value})
+		}
+		registers[register] = events
+	}
+	return registers, nil
+}
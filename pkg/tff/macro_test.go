@@ -0,0 +1,209 @@
+package tff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+const macroYaml = `
+combos:
+  - keys: q w
+    macro: record a
+  - keys: q t
+    macro: record b
+  - keys: q e
+    macro: stop
+  - keys: q r
+    macro: play a
+  - keys: q y
+    macro: play b
+  - keys: f j
+    outKeys: x
+`
+
+func runMacroTest(t *testing.T, yaml string, input string) *writeToSlice {
+	t.Helper()
+	config, err := LoadYamlFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString(input)
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	require.NoError(t, manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil))
+	return &ew
+}
+
+func Test_Macro_RecordAndPlay(t *testing.T) {
+	// Record "a" and the output of the combo f j. Then play it.
+	ew := runMacroTest(t, macroYaml,
+		"q_ (20ms) w_ (100ms) w/ (20ms) q/ (20ms) a_ (20ms) a/ (20ms) f_ (20ms) j_ (100ms) j/ (20ms) f/ "+
+			"(20ms) q_ (20ms) e_ (100ms) e/ (20ms) q/ (20ms) q_ (20ms) r_ (100ms) r/ (20ms) q/ (20ms) b_ (20ms) b/")
+	ew.requireEqual(t, `
+		A-down
+		A-up
+		X-down
+		X-up
+		A-down
+		A-up
+		X-down
+		X-up
+		B-down
+		B-up
+		`)
+}
+
+func Test_Macro_PlaybackDoesNotGetRecorded(t *testing.T) {
+	// Record b: play a, then type c. Only c gets recorded into b.
+	ew := runMacroTest(t, macroYaml,
+		"q_ (20ms) w_ (100ms) w/ (20ms) q/ (20ms) a_ (20ms) a/ (20ms) q_ (20ms) e_ (100ms) e/ (20ms) q/ "+
+			"(20ms) q_ (20ms) t_ (100ms) t/ (20ms) q/ (20ms) q_ (20ms) r_ (100ms) r/ (20ms) q/ (20ms) c_ (20ms) c/ "+
+			"(20ms) q_ (20ms) e_ (100ms) e/ (20ms) q/ (20ms) q_ (20ms) y_ (100ms) y/ (20ms) q/")
+	ew.requireEqual(t, `
+		A-down
+		A-up
+		A-down
+		A-up
+		C-down
+		C-up
+		C-down
+		C-up
+		`)
+}
+
+func Test_Macro_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "macros.json")
+	yaml := macroYaml + "macroFile: " + path + "\n"
+	runMacroTest(t, yaml,
+		"q_ (20ms) w_ (100ms) w/ (20ms) q/ (20ms) a_ (20ms) a/ (20ms) q_ (20ms) e_ (100ms) e/ (20ms) q/")
+	registers, err := loadMacros(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]Event{
+		"a": {
+			{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: DOWN},
+			{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: UP},
+		},
+	}, registers)
+
+	// The next session plays the register of the file.
+	resetMacroRegistry()
+	ew := runMacroTest(t, yaml, "q_ (20ms) r_ (100ms) r/ (20ms) q/")
+	ew.requireEqual(t, `
+		A-down
+		A-up
+		`)
+}
+
+func Test_Macro_SharedRegisters(t *testing.T) {
+	// Without 'devices', each device has its own engine. The engines must not overwrite the
+	// registers of each other.
+	path := filepath.Join(t.TempDir(), "macros.json")
+	config, err := LoadYamlFromBytes([]byte(macroYaml + "macroFile: " + path + "\n"))
+	require.NoError(t, err)
+	resetMacroRegistry()
+	require.NoError(t, loadMacroRegistry(path))
+	engines := []*State{
+		NewState(config, &writeToSlice{}),
+		NewState(config, &writeToSlice{}),
+	}
+	for i, key := range []KeyCode{evdev.KEY_A, evdev.KEY_B} {
+		state := engines[i]
+		state.macros.register = strings.ToLower(keyToString(key))
+		require.NoError(t, state.WriteEvent(Event{Type: evdev.EV_KEY, Code: key, Value: DOWN}, "test"))
+		require.NoError(t, state.WriteEvent(Event{Type: evdev.EV_KEY, Code: key, Value: UP}, "test"))
+		state.stopMacroRecording()
+	}
+	registers, err := loadMacros(path)
+	require.NoError(t, err)
+	require.Len(t, registers, 2)
+	events, ok := macroRegister(path, "a")
+	require.True(t, ok)
+	require.Equal(t, KeyCode(evdev.KEY_A), events[0].Code)
+}
+
+func Test_loadMacroRegistry_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "macros.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	resetMacroRegistry()
+	require.ErrorContains(t, loadMacroRegistry(path), "failed to load macros from")
+}
+
+func Test_storeMacro_InvalidFileDoesNotGetOverwritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "macros.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	resetMacroRegistry()
+	events := []Event{{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: DOWN}}
+	require.ErrorContains(t, storeMacro(path, "a", events), "failed to load macros from")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "{", string(data))
+}
+
+func resetMacroRegistry() {
+	macroRegistry.Lock()
+	defer macroRegistry.Unlock()
+	macroRegistry.path = ""
+	macroRegistry.loaded = false
+	macroRegistry.registers = nil
+	macroRegistry.loadErr = nil
+}
+
+func Test_balancedMacro(t *testing.T) {
+	ev := func(key KeyCode, value int32) Event {
+		return Event{Type: evdev.EV_KEY, Code: key, Value: value}
+	}
+	// leftshift was pressed before the recording. leftctrl is still pressed at the end.
+	require.Equal(t, []Event{
+		ev(evdev.KEY_LEFTCTRL, DOWN),
+		ev(evdev.KEY_A, DOWN),
+		ev(evdev.KEY_A, UP),
+		ev(evdev.KEY_LEFTCTRL, UP),
+	}, balancedMacro([]Event{
+		ev(evdev.KEY_LEFTSHIFT, UP),
+		ev(evdev.KEY_LEFTCTRL, DOWN),
+		ev(evdev.KEY_A, DOWN),
+		ev(evdev.KEY_A, UP),
+	}))
+}
+
+func Test_stringToMacroAction(t *testing.T) {
+	action, err := stringToMacroAction("play a")
+	require.NoError(t, err)
+	require.Equal(t, MacroAction{Type: MacroPlay, Register: "a"}, *action)
+
+	_, err = stringToMacroAction("stop a")
+	require.ErrorContains(t, err, `'stop' has no register`)
+	_, err = stringToMacroAction("record")
+	require.ErrorContains(t, err, `Expected the name of the register, like 'record a'`)
+	_, err = stringToMacroAction("rewind a")
+	require.ErrorContains(t, err, `unknown macro action "rewind"`)
+}
+
+func Test_Macro_PlaybackWithPacing(t *testing.T) {
+	// The pacing does not block the engine. c gets pressed during the playback, and gets written
+	// after it.
+	ew := runMacroTest(t, macroYaml+"outputPacing: 50ms\n",
+		"q_ (20ms) w_ (100ms) w/ (20ms) q/ (20ms) a_ (20ms) a/ (20ms) q_ (20ms) e_ (100ms) e/ (20ms) q/ "+
+			"(20ms) q_ (20ms) r_ (100ms) r/ (20ms) q/ (10ms) c_ (10ms) c/")
+	ew.requireEqual(t, `
+		A-down
+		A-up
+		A-down
+		A-up
+		C-down
+		C-up
+		`)
+	var times []time.Time
+	for _, ev := range ew.s {
+		if ev.Type == evdev.EV_KEY {
+			times = append(times, syscallTimevalToTime(ev.Time))
+		}
+	}
+	require.Equal(t, 50*time.Millisecond, times[3].Sub(times[2]))
+	require.False(t, times[4].Before(times[3]))
+}
@@ -0,0 +1,182 @@
+package tff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// modifierKeys maps the names which can be used in 'modifiers' of a combo to the keys.
+// "shift" means: left or right shift.
+var modifierKeys = map[string][]KeyCode{
+	"shift":      {evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT},
+	"ctrl":       {evdev.KEY_LEFTCTRL, evdev.KEY_RIGHTCTRL},
+	"alt":        {evdev.KEY_LEFTALT, evdev.KEY_RIGHTALT},
+	"meta":       {evdev.KEY_LEFTMETA, evdev.KEY_RIGHTMETA},
+	"super":      {evdev.KEY_LEFTMETA, evdev.KEY_RIGHTMETA},
+	"leftshift":  {evdev.KEY_LEFTSHIFT},
+	"rightshift": {evdev.KEY_RIGHTSHIFT},
+	"leftctrl":   {evdev.KEY_LEFTCTRL},
+	"rightctrl":  {evdev.KEY_RIGHTCTRL},
+	"leftalt":    {evdev.KEY_LEFTALT},
+	"rightalt":   {evdev.KEY_RIGHTALT},
+	"leftmeta":   {evdev.KEY_LEFTMETA},
+	"rightmeta":  {evdev.KEY_RIGHTMETA},
+}
+
+func isModifier(key KeyCode) bool {
+	switch key {
+	case evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT,
+		evdev.KEY_LEFTCTRL, evdev.KEY_RIGHTCTRL,
+		evdev.KEY_LEFTALT, evdev.KEY_RIGHTALT,
+		evdev.KEY_LEFTMETA, evdev.KEY_RIGHTMETA:
+		return true
+	}
+	return false
+}
+
+// stringToModifiers parses the 'modifiers' of a combo. Example: "shift leftctrl".
+// Each entry of the result contains the keys which satisfy one modifier.
+func stringToModifiers(str string) ([][]KeyCode, error) {
+	var modifiers [][]KeyCode
+	for _, word := range strings.Fields(str) {
+		keys, ok := modifierKeys[word]
+		if !ok {
+			return nil, fmt.Errorf("unknown modifier %q", word)
+		}
+		modifiers = append(modifiers, keys)
+	}
+	return modifiers, nil
+}
+
+func modifiersString(modifiers [][]KeyCode) string {
+	s := make([]string, 0, len(modifiers))
+	for _, keys := range modifiers {
+		name := strings.ToLower(keyToString(keys[0]))
+		if len(keys) > 1 {
+			name = strings.TrimPrefix(name, "left")
+		}
+		s = append(s, name)
+	}
+	return strings.Join(s, "+")
+}
+
+func modifiersEqual(a, b [][]KeyCode) bool {
+	return slices.EqualFunc(a, b, slices.Equal)
+}
+
+// modifiersHeld returns true if all modifiers of the combo are held.
+func (state *State) modifiersHeld(combo *Combo) bool {
+	for _, keys := range combo.Modifiers {
+		if !slices.ContainsFunc(keys, func(key KeyCode) bool { return state.keysDown[key] }) {
+			return false
+		}
+	}
+	return true
+}
+
+// usedInCombos returns true if the key is part of a combo of the active layer.
+func (state *State) usedInCombos(key KeyCode) bool {
+	return len(state.combosLayer.combosWithKey(key)) > 0
+}
+
+// handleModifierKey passes modifiers, which are not part of a combo, directly to the output.
+// They do not enter the buffer, so they do not break a combo which gets pressed while the
+// modifier is held. The held modifier applies to the output of the combo.
+// The up-event gets deferred while the buffer contains undecided keys. Otherwise the modifier
+// would be released before the keys in the buffer get written.
+func (state *State) handleModifierKey(ev Event) (bool, error) {
+	if !isModifier(ev.Code) {
+		return false, nil
+	}
+	switch ev.Value {
+	case DOWN:
+		if len(state.buf) > 0 || state.usedInCombos(ev.Code) {
+			return false, nil
+		}
+		state.passedModifiers = append(state.passedModifiers, ev.Code)
+		return true, state.WriteEvent(ev, "Modifier")
+	case UP:
+		i := slices.Index(state.passedModifiers, ev.Code)
+		if i == -1 {
+			return false, nil
+		}
+		state.passedModifiers = slices.Delete(state.passedModifiers, i, i+1)
+		state.deferredModifierUps = append(state.deferredModifierUps, ev)
+		return true, state.writeDeferredModifierUps()
+	}
+	return false, nil
+}
+
+// writeDeferredModifierUps writes the up-events of modifiers, as soon as the buffer contains no
+// undecided keys.
+func (state *State) writeDeferredModifierUps() error {
+	if len(state.deferredModifierUps) == 0 {
+		return nil
+	}
+	if len(state.buf) > 0 && len(state.downKeysWritten) == 0 {
+		return nil
+	}
+	ups := state.deferredModifierUps
+	state.deferredModifierUps = nil
+	for _, ev := range ups {
+		if err := state.WriteEvent(ev, "Modifier>deferred-up"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeModifiers releases the held modifiers which are required by the combo, before the
+// output of the combo gets written. They get pressed again by restoreModifiers.
+func (state *State) consumeModifiers(combo *Combo, ev Event) error {
+	for _, keys := range combo.Modifiers {
+		for _, key := range keys {
+			if !state.keysDown[key] {
+				continue
+			}
+			ev.Code = key
+			ev.Value = UP
+			if err := state.WriteEvent(ev, "Modifier>consumed by "+combo.String()); err != nil {
+				return err
+			}
+			state.consumedModifiers = append(state.consumedModifiers, key)
+		}
+	}
+	return nil
+}
+
+// restoreModifiers presses the consumed modifiers again, if they are still held.
+func (state *State) restoreModifiers(ev Event) error {
+	consumed := state.consumedModifiers
+	state.consumedModifiers = nil
+	for _, key := range consumed {
+		ev.Code = key
+		ev.Value = DOWN
+		if err := state.WriteEvent(ev, "Modifier>restore"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preferCombosWithModifiers: if a combo with modifiers matches, then a combo with the same keys
+// but without modifiers must not fire, too.
+func preferCombosWithModifiers(combos []*Combo, codes []evalResult) {
+	for i, combo := range combos {
+		if len(combo.Modifiers) == 0 || !matched(codes[i]) {
+			continue
+		}
+		for j, other := range combos {
+			if len(other.Modifiers) == 0 && matched(codes[j]) && combosOverlap(combo, other) {
+				codes[j] = NoMatch
+			}
+		}
+	}
+}
+
+func matched(code evalResult) bool {
+	return code == WriteUpKeys || code == AllDownKeysSeen || code == AllDownKeysSeenAndAlreadyWritten
+}
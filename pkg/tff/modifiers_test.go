@@ -0,0 +1,132 @@
+package tff
+
+import (
+	"testing"
+)
+
+var modifiersYaml = `
+combos:
+  - keys: f j
+    outKeys: down
+  - keys: f j
+    outKeys: pagedown
+    modifiers: ctrl
+  - keys: leftalt j
+    outKeys: x
+`
+
+func Test_Modifiers_HeldBeforeCombo(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		leftshift_ (50ms) f_ (50ms) j_ (200ms) j/ (10ms) f/ (50ms) leftshift/
+	`,
+		`
+		LEFTSHIFT-down
+		DOWN-down
+		DOWN-up
+		LEFTSHIFT-up
+	`,
+		modifiersYaml)
+}
+
+func Test_Modifiers_UpDeferredWhileBufferUndecided(t *testing.T) {
+	// The shift gets released, before it is decided that f j is a combo.
+	AssertYamlStateStringInputOutput(t,
+		`
+		leftshift_ (50ms) f_ (50ms) j_ (10ms) leftshift/ (200ms) j/ (10ms) f/
+	`,
+		`
+		LEFTSHIFT-down
+		DOWN-down
+		LEFTSHIFT-up
+		DOWN-up
+	`,
+		modifiersYaml)
+}
+
+func Test_Modifiers_FluentTyping(t *testing.T) {
+	// The shift gets pressed while f is in the buffer. The order must be kept.
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (20ms) leftshift_ (20ms) f/ (20ms) j_ (20ms) leftshift/ (10ms) j/
+	`,
+		`
+		F-down
+		LEFTSHIFT-down
+		F-up
+		J-down
+		LEFTSHIFT-up
+		J-up
+	`,
+		modifiersYaml)
+}
+
+func Test_Modifiers_ComboWithModifiers(t *testing.T) {
+	// The ctrl gets released while the output of the combo gets written.
+	AssertYamlStateStringInputOutput(t,
+		`
+		rightctrl_ (50ms) f_ (50ms) j_ (200ms) j/ (10ms) f/ (50ms) rightctrl/
+	`,
+		`
+		RIGHTCTRL-down
+		RIGHTCTRL-up
+		PAGEDOWN-down
+		PAGEDOWN-up
+		RIGHTCTRL-down
+		RIGHTCTRL-up
+	`,
+		modifiersYaml)
+}
+
+func Test_Modifiers_ComboWithModifiersWritesModifier(t *testing.T) {
+	// The output of the combo presses and releases ctrl itself. Its up-event must get written.
+	AssertYamlStateStringInputOutput(t,
+		`
+		leftctrl_ (50ms) f_ (50ms) j_ (200ms) j/ (10ms) f/ (50ms) leftctrl/
+	`,
+		`
+		LEFTCTRL-down
+		LEFTCTRL-up
+		LEFTCTRL-down
+		A-down
+		A-up
+		LEFTCTRL-up
+		DELETE-down
+		DELETE-up
+		LEFTCTRL-down
+		LEFTCTRL-up
+	`,
+		`
+combos:
+  - keys: f j
+    outKeys: ctrl+a, delete
+    modifiers: leftctrl
+`)
+}
+
+func Test_Modifiers_ReleasedWhileComboHeld(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		leftctrl_ (50ms) f_ (50ms) j_ (200ms) leftctrl/ (50ms) j/ (10ms) f/
+	`,
+		`
+		LEFTCTRL-down
+		LEFTCTRL-up
+		PAGEDOWN-down
+		PAGEDOWN-up
+	`,
+		modifiersYaml)
+}
+
+func Test_Modifiers_PartOfCombo(t *testing.T) {
+	// leftalt is part of a combo. It does not get passed directly.
+	AssertYamlStateStringInputOutput(t,
+		`
+		leftalt_ (50ms) j_ (200ms) j/ (10ms) leftalt/
+	`,
+		`
+		X-down
+		X-up
+	`,
+		modifiersYaml)
+}
@@ -0,0 +1,238 @@
+package tff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+type MouseActionType string
+
+const (
+	// MouseMove moves the pointer while the key is held. The pointer gets faster.
+	MouseMove MouseActionType = "move"
+
+	// MouseClick presses a mouse button while the key is held.
+	MouseClick MouseActionType = "click"
+
+	// MouseWheel scrolls while the key is held.
+	MouseWheel MouseActionType = "wheel"
+)
+
+// MouseAction is the 'mouse' of a combo or a remap. Examples: "move up", "click left",
+// "wheel down".
+type MouseAction struct {
+	Type MouseActionType
+
+	// Direction is up, down, left or right. For clicks it is the button: left, middle or right.
+	Direction string
+}
+
+func (a MouseAction) String() string {
+	return fmt.Sprintf("%s %s", a.Type, a.Direction)
+}
+
+var mouseButtons = map[string]KeyCode{
+	"left":   evdev.BTN_LEFT,
+	"middle": evdev.BTN_MIDDLE,
+	"right":  evdev.BTN_RIGHT,
+}
+
+// mouseDirections: the code of the relative axis, and the sign of the value.
+var mouseDirections = map[MouseActionType]map[string]struct {
+	code evdev.EvCode
+	sign int32
+}{
+	MouseMove: {
+		"up":    {evdev.REL_Y, -1},
+		"down":  {evdev.REL_Y, 1},
+		"left":  {evdev.REL_X, -1},
+		"right": {evdev.REL_X, 1},
+	},
+	MouseWheel: {
+		"up":    {evdev.REL_WHEEL, 1},
+		"down":  {evdev.REL_WHEEL, -1},
+		"left":  {evdev.REL_HWHEEL, -1},
+		"right": {evdev.REL_HWHEEL, 1},
+	},
+}
+
+func stringToMouseAction(str string) (*MouseAction, error) {
+	words := strings.Fields(str)
+	if len(words) != 2 {
+		return nil, fmt.Errorf("invalid mouse action %q. Expected something like 'move up', 'click left' or 'wheel down'", str)
+	}
+	action := MouseAction{
+		Type:      MouseActionType(words[0]),
+		Direction: words[1],
+	}
+	switch action.Type {
+	case MouseClick:
+		if _, ok := mouseButtons[action.Direction]; !ok {
+			return nil, fmt.Errorf("invalid mouse button %q. Valid buttons: left, middle, right", action.Direction)
+		}
+	case MouseMove, MouseWheel:
+		if _, ok := mouseDirections[action.Type][action.Direction]; !ok {
+			return nil, fmt.Errorf("invalid direction %q of %q. Valid directions: up, down, left, right",
+				action.Direction, str)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mouse action %q. Valid actions: %s, %s, %s", words[0],
+			MouseMove, MouseClick, MouseWheel)
+	}
+	return &action, nil
+}
+
+// Mouse configures the speed of the mouse actions.
+type Mouse struct {
+	// Interval is the time between two movements of the pointer.
+	Interval time.Duration
+
+	// Speed is the distance of the first movement. The distance grows to MaxSpeed within the
+	// duration of Acceleration.
+	Speed        int32
+	MaxSpeed     int32
+	Acceleration time.Duration
+
+	// WheelInterval is the time between two scroll steps.
+	WheelInterval time.Duration
+}
+
+var DefaultMouse = Mouse{
+	Interval:      20 * time.Millisecond,
+	Speed:         4,
+	MaxSpeed:      30,
+	Acceleration:  time.Second,
+	WheelInterval: 100 * time.Millisecond,
+}
+
+type yamlMouse struct {
+	Interval      time.Duration `yaml:"interval"`
+	Speed         int32         `yaml:"speed"`
+	MaxSpeed      int32         `yaml:"maxSpeed"`
+	Acceleration  time.Duration `yaml:"acceleration"`
+	WheelInterval time.Duration `yaml:"wheelInterval"`
+}
+
+func yamlMouseToMouse(y yamlMouse) (Mouse, error) {
+	if y.Interval < 0 || y.Speed < 0 || y.MaxSpeed < 0 || y.Acceleration < 0 || y.WheelInterval < 0 {
+		return Mouse{}, fmt.Errorf("negative values in 'mouse' are not allowed.")
+	}
+	m := Mouse(y)
+	if m.Interval == 0 {
+		m.Interval = DefaultMouse.Interval
+	}
+	if m.Speed == 0 {
+		m.Speed = DefaultMouse.Speed
+	}
+	if m.MaxSpeed == 0 {
+		m.MaxSpeed = max(DefaultMouse.MaxSpeed, m.Speed)
+	}
+	if m.Acceleration == 0 {
+		m.Acceleration = DefaultMouse.Acceleration
+	}
+	if m.WheelInterval == 0 {
+		m.WheelInterval = DefaultMouse.WheelInterval
+	}
+	if m.MaxSpeed < m.Speed {
+		return Mouse{}, fmt.Errorf("'maxSpeed' in 'mouse' must not be smaller than 'speed'.")
+	}
+	return m, nil
+}
+
+// distance returns the distance of one movement, after the key was held for the duration.
+func (m Mouse) distance(held time.Duration) int32 {
+	if held >= m.Acceleration {
+		return m.MaxSpeed
+	}
+	return m.Speed + int32(int64(m.MaxSpeed-m.Speed)*int64(held)/int64(m.Acceleration))
+}
+
+// mouseCapabilities get added to the output device. The source keyboard usually has no mouse
+// capabilities.
+var mouseCapabilities = map[evdev.EvType][]evdev.EvCode{
+	evdev.EV_KEY: {evdev.BTN_LEFT, evdev.BTN_MIDDLE, evdev.BTN_RIGHT},
+	evdev.EV_REL: {evdev.REL_X, evdev.REL_Y, evdev.REL_WHEEL, evdev.REL_HWHEEL},
+}
+
+// heldMouseAction is a movement or a scroll, which gets repeated while the key is held.
+type heldMouseAction struct {
+	action MouseAction
+	since  time.Time
+	next   time.Time
+}
+
+// handleMouseAction handles down and up of a key or combo with a mouse action.
+func (state *State) handleMouseAction(action *MouseAction, value upDownValue, t syscall.Timeval) error {
+	if value == REPEAT {
+		// The movement gets repeated by the mouse timer, not by the autorepeat of the kernel.
+		return nil
+	}
+	if action.Type == MouseClick {
+		return state.WriteEvent(Event{
+			Time:  t,
+			Type:  evdev.EV_KEY,
+			Code:  mouseButtons[action.Direction],
+			Value: value,
+		}, "Mouse "+action.String())
+	}
+	if value == UP {
+		i := slices.IndexFunc(state.heldMouseActions, func(held *heldMouseAction) bool {
+			return held.action == *action
+		})
+		if i != -1 {
+			state.heldMouseActions = slices.Delete(state.heldMouseActions, i, i+1)
+		}
+		state.updateMouseDeadline()
+		return nil
+	}
+	now := syscallTimevalToTime(t)
+	state.heldMouseActions = append(state.heldMouseActions, &heldMouseAction{
+		action: *action,
+		since:  now,
+		next:   now,
+	})
+	return state.afterMouseTimer(now)
+}
+
+// afterMouseTimer writes the movements and scroll steps which are due.
+func (state *State) afterMouseTimer(now time.Time) error {
+	mouse := state.config.Mouse
+	for _, held := range state.heldMouseActions {
+		if held.next.After(now) {
+			continue
+		}
+		direction := mouseDirections[held.action.Type][held.action.Direction]
+		value := direction.sign
+		interval := mouse.WheelInterval
+		if held.action.Type == MouseMove {
+			value *= mouse.distance(now.Sub(held.since))
+			interval = mouse.Interval
+		}
+		err := state.WriteEvent(Event{
+			Time:  timeToSyscallTimeval(now),
+			Type:  evdev.EV_REL,
+			Code:  direction.code,
+			Value: value,
+		}, "Mouse "+held.action.String())
+		if err != nil {
+			return err
+		}
+		held.next = now.Add(interval)
+	}
+	state.updateMouseDeadline()
+	return nil
+}
+
+func (state *State) updateMouseDeadline() {
+	state.mouseDeadline = maxTime
+	for _, held := range state.heldMouseActions {
+		if held.next.Before(state.mouseDeadline) {
+			state.mouseDeadline = held.next
+		}
+	}
+}
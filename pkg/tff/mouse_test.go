@@ -0,0 +1,93 @@
+package tff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+const mouseYaml = `
+mouse:
+  interval: 20ms
+  speed: 2
+  maxSpeed: 10
+  acceleration: 100ms
+  wheelInterval: 50ms
+remaps:
+  - key: up
+    mouse: move up
+  - key: capslock
+    mouse: click left
+combos:
+  - keys: f j
+    mouse: wheel down
+`
+
+// runMouseTest returns the written key and mouse events like "y-2" or "a_".
+func runMouseTest(t *testing.T, input string) []string {
+	t.Helper()
+	config, err := LoadYamlFromBytes([]byte(mouseYaml))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString(input)
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	require.NoError(t, manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil))
+	var lines []string
+	for _, ev := range ew.s {
+		if ev.Type == evdev.EV_KEY || ev.Type == evdev.EV_REL {
+			lines = append(lines, eventToString(&ev, nil))
+		}
+	}
+	return lines
+}
+
+func Test_Mouse_Move(t *testing.T) {
+	// The pointer gets faster, while the key is held.
+	require.Equal(t, []string{"y-2", "y-3", "y-5", "y-6", "y-8", "y-10", "y-10", "a_", "a/"},
+		runMouseTest(t, "up_ (60ms) up= (70ms) up/ (20ms) a_ (20ms) a/"))
+}
+
+func Test_Mouse_Click(t *testing.T) {
+	require.Equal(t, []string{"btn_left_", "btn_left/", "a_", "a/"},
+		runMouseTest(t, "capslock_ (100ms) capslock/ (20ms) a_ (20ms) a/"))
+}
+
+func Test_Mouse_WheelCombo(t *testing.T) {
+	lines := runMouseTest(t, "f_ (20ms) j_ (200ms) j/ (20ms) f/ (20ms) a_ (20ms) a/")
+	require.Equal(t, "wheel-1", lines[0])
+	require.Equal(t, []string{"a_", "a/"}, lines[len(lines)-2:])
+	for _, line := range lines[:len(lines)-2] {
+		require.Equal(t, "wheel-1", line)
+	}
+}
+
+func Test_Mouse_LoadYaml(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(mouseYaml))
+	require.NoError(t, err)
+	require.Equal(t, "KEY_F KEY_J -> mouse wheel down", config.BaseLayer().Combos[0].String())
+	require.Equal(t, Mouse{
+		Interval:      20 * time.Millisecond,
+		Speed:         2,
+		MaxSpeed:      10,
+		Acceleration:  100 * time.Millisecond,
+		WheelInterval: 50 * time.Millisecond,
+	}, config.Mouse)
+
+	for _, tt := range []struct {
+		yaml string
+		err  string
+	}{
+		{"remaps:\n  - key: up\n    mouse: jump up\n", `unknown mouse action "jump"`},
+		{"remaps:\n  - key: up\n    mouse: move forward\n", `invalid direction "forward" of "move forward"`},
+		{"remaps:\n  - key: up\n    mouse: click back\n", `invalid mouse button "back"`},
+		{"remaps:\n  - key: up\n    mouse: move up\n    outKey: a\n", `'mouse' can not be used with 'outKey' or 'action'.`},
+		{"mouse:\n  speed: 50\n  maxSpeed: 10\n", `'maxSpeed' in 'mouse' must not be smaller than 'speed'.`},
+		{"mouse:\n  interval: -1s\n", `negative values in 'mouse' are not allowed.`},
+	} {
+		_, err := LoadYamlFromBytes([]byte(tt.yaml))
+		require.ErrorContains(t, err, tt.err, tt.yaml)
+	}
+}
@@ -0,0 +1,153 @@
+package tff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	OrderStrict = "strict"
+	OrderAny    = "any"
+)
+
+// stringToKeyGroups parses the keys of a combo. Keys in curly braces can be pressed in any
+// order. Example: "f {j k}" means "f" first, then "j" and "k" in any order.
+// keyGroups is nil, if the string contains no curly braces.
+func stringToKeyGroups(str string) (keys []KeyCode, keyGroups [][]KeyCode, err error) {
+	str = strings.ReplaceAll(str, "{", " { ")
+	str = strings.ReplaceAll(str, "}", " } ")
+	var group []KeyCode
+	inGroup := false
+	for _, word := range strings.Fields(str) {
+		switch word {
+		case "{":
+			if inGroup {
+				return nil, nil, fmt.Errorf("nested curly braces are not allowed: %q", str)
+			}
+			inGroup = true
+			group = nil
+		case "}":
+			if !inGroup {
+				return nil, nil, fmt.Errorf("closing curly brace without opening curly brace: %q", str)
+			}
+			if len(group) == 0 {
+				return nil, nil, fmt.Errorf("empty curly braces are not allowed: %q", str)
+			}
+			inGroup = false
+			keyGroups = append(keyGroups, group)
+		default:
+			key, err := wordToKeyCode(word)
+			if err != nil {
+				return nil, nil, err
+			}
+			if slices.Contains(keys, key) {
+				return nil, nil, fmt.Errorf("key %q is used twice", word)
+			}
+			keys = append(keys, key)
+			if inGroup {
+				group = append(group, key)
+			} else {
+				keyGroups = append(keyGroups, []KeyCode{key})
+			}
+		}
+	}
+	if inGroup {
+		return nil, nil, fmt.Errorf("missing closing curly brace: %q", str)
+	}
+	if len(keyGroups) == len(keys) {
+		// No curly braces: strict order.
+		keyGroups = nil
+	}
+	return keys, keyGroups, nil
+}
+
+// groupIndexOfPosition returns the index of the group which contains the n-th key.
+func (c *Combo) groupIndexOfPosition(pos int) int {
+	if c.KeyGroups == nil {
+		return pos
+	}
+	for i, group := range c.KeyGroups {
+		if pos < len(group) {
+			return i
+		}
+		pos -= len(group)
+	}
+	return -1
+}
+
+// groupIndexOfKey returns the index of the group which contains the key.
+func (c *Combo) groupIndexOfKey(key KeyCode) int {
+	if c.KeyGroups == nil {
+		return slices.Index(c.Keys, key)
+	}
+	for i, group := range c.KeyGroups {
+		if slices.Contains(group, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// keyAllowedAt returns true if the key may be the n-th key pressed down.
+func (c *Combo) keyAllowedAt(pos int, key KeyCode) bool {
+	if c.KeyGroups == nil {
+		return c.Keys[pos] == key
+	}
+	groupIndex := c.groupIndexOfPosition(pos)
+	return groupIndex != -1 && slices.Contains(c.KeyGroups[groupIndex], key)
+}
+
+// combosOverlap returns true, if there is an order of pressing the keys which matches both combos.
+func combosOverlap(a, b *Combo) bool {
+	if len(a.Keys) != len(b.Keys) {
+		return false
+	}
+	for _, key := range a.Keys {
+		if !slices.Contains(b.Keys, key) {
+			return false
+		}
+	}
+	// A key can be at a position, if the key is in the group of the position in both combos.
+	// An order which matches both exists, if for each pair of groups, the number of positions
+	// equals the number of keys.
+	type groupPair struct{ a, b int }
+	counts := make(map[groupPair]int, len(a.Keys))
+	for pos, key := range a.Keys {
+		counts[groupPair{a.groupIndexOfPosition(pos), b.groupIndexOfPosition(pos)}]++
+		counts[groupPair{a.groupIndexOfKey(key), b.groupIndexOfKey(key)}]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Combo) keysString() string {
+	groups := c.KeyGroups
+	if groups == nil {
+		groups = make([][]KeyCode, 0, len(c.Keys))
+		for _, key := range c.Keys {
+			groups = append(groups, []KeyCode{key})
+		}
+	}
+	s := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names := make([]string, 0, len(group))
+		for _, key := range group {
+			name := keyCodeName(key)
+			if device := c.KeyDevices[key]; device != "" {
+				name += "@" + device
+			}
+			names = append(names, name)
+		}
+		if len(group) == 1 {
+			s = append(s, names[0])
+			continue
+		}
+		s = append(s, "{"+strings.Join(names, " ")+"}")
+	}
+	return strings.Join(s, " ")
+}
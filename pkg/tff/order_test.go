@@ -0,0 +1,106 @@
+package tff
+
+import (
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+var orderYaml = `
+combos:
+  - keys: f j
+    outKeys: x
+    order: any
+  - keys: d {k l}
+    outKeys: y
+`
+
+func Test_Order_Any(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (20ms) j_ (200ms) j/ (10ms) f/ (300ms)
+		j_ (20ms) f_ (200ms) f/ (10ms) j/
+	`,
+		`
+		X-down
+		X-up
+		X-down
+		X-up
+	`,
+		orderYaml)
+}
+
+func Test_Order_Partial(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		d_ (20ms) k_ (20ms) l_ (200ms) l/ (10ms) k/ (10ms) d/ (300ms)
+		d_ (20ms) l_ (20ms) k_ (200ms) k/ (10ms) l/ (10ms) d/
+	`,
+		`
+		Y-down
+		Y-up
+		Y-down
+		Y-up
+	`,
+		orderYaml)
+}
+
+func Test_Order_Partial_Wrong(t *testing.T) {
+	// "d" must be pressed first.
+	AssertYamlStateStringInputOutput(t,
+		`
+		k_ (20ms) d_ (20ms) l_ (200ms) l/ (10ms) d/ (10ms) k/
+	`,
+		`
+		K-down
+		D-down
+		L-down
+		L-up
+		D-up
+		K-up
+	`,
+		orderYaml)
+}
+
+func Test_stringToKeyGroups(t *testing.T) {
+	keys, groups, err := stringToKeyGroups("f {j k} l")
+	require.NoError(t, err)
+	require.Equal(t, []KeyCode{evdev.KEY_F, evdev.KEY_J, evdev.KEY_K, evdev.KEY_L}, keys)
+	require.Equal(t, [][]KeyCode{{evdev.KEY_F}, {evdev.KEY_J, evdev.KEY_K}, {evdev.KEY_L}}, groups)
+
+	keys, groups, err = stringToKeyGroups("f j")
+	require.NoError(t, err)
+	require.Equal(t, []KeyCode{evdev.KEY_F, evdev.KEY_J}, keys)
+	require.Nil(t, groups)
+
+	for _, s := range []string{"f {j k", "f j}", "f {j {k}}", "f {}", "f j f"} {
+		_, _, err = stringToKeyGroups(s)
+		require.Error(t, err, s)
+	}
+}
+
+func Test_combosOverlap(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"f j", "j f", false},
+		{"f j", "f j", true},
+		{"{f j}", "j f", true},
+		{"f {j k}", "f k j", true},
+		{"f {j k}", "j f k", false},
+		{"{f j} k", "f {j k}", true},
+		{"f j", "f j k", false},
+	}
+	for _, tt := range tests {
+		a := &Combo{}
+		b := &Combo{}
+		var err error
+		a.Keys, a.KeyGroups, err = stringToKeyGroups(tt.a)
+		require.NoError(t, err)
+		b.Keys, b.KeyGroups, err = stringToKeyGroups(tt.b)
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, combosOverlap(a, b), "%s / %s", tt.a, tt.b)
+	}
+}
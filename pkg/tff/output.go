@@ -0,0 +1,228 @@
+package tff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// OutputStep is one step of the output of a combo. Either a chord (Keys get pressed together)
+// or a delay.
+type OutputStep struct {
+	Keys  []KeyCode
+	Delay time.Duration
+}
+
+func (step OutputStep) String() string {
+	if step.Keys == nil {
+		return step.Delay.String()
+	}
+	names := make([]string, 0, len(step.Keys))
+	for _, key := range step.Keys {
+		names = append(names, strings.ToLower(keyToString(key)))
+	}
+	return strings.Join(names, "+")
+}
+
+// modifierAliases can be used in outKeys. For example "ctrl+shift+t".
+var modifierAliases = map[string]string{
+	"ctrl":  "leftctrl",
+	"shift": "leftshift",
+	"alt":   "leftalt",
+	"meta":  "leftmeta",
+	"super": "leftmeta",
+}
+
+// stringToOutput parses outKeys. Steps are separated by commas. Keys in a step are separated
+// by spaces or "+". They get pressed together. A step can be a delay like "20ms".
+// Examples: "leftctrl c", "ctrl+shift+t", "g, g", "ctrl+a, 20ms, delete".
+// output is nil, if the string contains only one chord.
+func stringToOutput(str string) (outKeys []KeyCode, output []OutputStep, err error) {
+	for _, stepString := range strings.Split(str, ",") {
+		words := strings.Fields(strings.ReplaceAll(stepString, "+", " "))
+		if len(words) == 0 {
+			return nil, nil, fmt.Errorf("empty step in %q", str)
+		}
+		if len(words) == 1 {
+			if _, err := wordToKeyCode(words[0]); err != nil {
+				if delay, durationErr := time.ParseDuration(words[0]); durationErr == nil {
+					if delay < 0 {
+						return nil, nil, fmt.Errorf("negative delay in %q", str)
+					}
+					output = append(output, OutputStep{Delay: delay})
+					continue
+				}
+			}
+		}
+		step := OutputStep{Keys: make([]KeyCode, 0, len(words))}
+		for _, word := range words {
+			if alias, ok := modifierAliases[word]; ok {
+				word = alias
+			}
+			key, err := wordToKeyCode(word)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := noScanKeys(key); err != nil {
+				return nil, nil, err
+			}
+			step.Keys = append(step.Keys, key)
+		}
+		outKeys = append(outKeys, step.Keys...)
+		output = append(output, step)
+	}
+	if len(output) == 1 && output[0].Keys != nil {
+		return outKeys, nil, nil
+	}
+	return outKeys, output, nil
+}
+
+// writeOutputSteps writes the output of a combo which has several steps.
+// Down: all steps but the last get pressed and released. The keys of the last step get pressed.
+// Up: the keys of the last step get released.
+// The keys of a chord get released in reverse order, so that modifiers get released last.
+// This way the last step is held as long as the combo is held.
+// The steps of 'outText' get all pressed and released on down. Up writes nothing.
+// Delays do not block the engine: the following events get queued (see queueOutput).
+func (state *State) writeOutputSteps(combo *Combo, t syscall.Timeval, value upDownValue) error {
+	steps := combo.Output
+	last := steps[len(steps)-1]
+	at := state.outputTime(syscallTimevalToTime(t))
+	var err error
+	if value == UP {
+		if combo.Text != "" {
+			return nil
+		}
+		_, err = state.writeChord(combo, reversed(last.Keys), t, at, UP)
+		return err
+	}
+	for i, step := range steps {
+		if step.Keys == nil {
+			at = at.Add(step.Delay)
+			continue
+		}
+		if at, err = state.writeChord(combo, step.Keys, t, at, DOWN); err != nil {
+			return err
+		}
+		if i == len(steps)-1 && combo.Text == "" {
+			break
+		}
+		if at, err = state.writeChord(combo, reversed(step.Keys), t, at, UP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChord writes the keys, starting at the time at. Before each event, the pacing of the
+// combo gets applied. Some applications drop events which arrive too fast. Returns the time of
+// the last event.
+func (state *State) writeChord(combo *Combo, keys []KeyCode, t syscall.Timeval, at time.Time,
+	value upDownValue,
+) (time.Time, error) {
+	pacing := combo.Pacing
+	if pacing == 0 {
+		pacing = state.config.OutputPacing
+	}
+	for _, key := range keys {
+		at = at.Add(pacing)
+		err := state.writeEventAt(evdev.InputEvent{
+			Time:  t,
+			Type:  evdev.EV_KEY,
+			Code:  key,
+			Value: value,
+		}, at, fmt.Sprintf("WriteCombo %s %s", eventValueToString[value], combo.String()))
+		if err != nil {
+			return at, fmt.Errorf("failed to write %s event: %w", eventValueToString[value], err)
+		}
+	}
+	return at, nil
+}
+
+// outputTime returns the time at which new output can be written: now, or the time of the last
+// queued event, if that is later.
+func (state *State) outputTime(now time.Time) time.Time {
+	if len(state.outputQueue) == 0 {
+		return now
+	}
+	last := syscallTimevalToTime(state.outputQueue[len(state.outputQueue)-1].ev.Time)
+	if last.After(now) {
+		return last
+	}
+	return now
+}
+
+// queuedEvent is an event of the output queue. ev.Time is the time to write it.
+type queuedEvent struct {
+	ev Event
+
+	// ew is the EventWriter of the engine, or the wrapped EventWriter for the playback of macros.
+	ew EventWriter
+
+	// input: ev is an event of the input which is not a key, for example a motion of the mouse.
+	// It gets written without an extra SYN_REPORT, because the input contains its own.
+	input bool
+}
+
+// queueOutput appends the event to the output queue. It gets written at the time at by
+// afterOutputTimer. This way delays and pacing do not block the engine: keys and timers get
+// handled while output is queued. Later output waits behind the queue, so the order of the output
+// does not change.
+func (state *State) queueOutput(ew EventWriter, ev Event, at time.Time) {
+	state.appendOutput(queuedEvent{ev: ev, ew: ew}, at)
+}
+
+// writeOrQueueInput writes an event of the input which is not a key. If output is queued, the
+// event waits behind it. Otherwise a mouse motion could overtake a button, for example.
+func (state *State) writeOrQueueInput(ew EventWriter, ev Event) error {
+	if len(state.outputQueue) == 0 {
+		return ew.WriteOne(&ev)
+	}
+	state.appendOutput(queuedEvent{ev: ev, ew: ew, input: true}, syscallTimevalToTime(ev.Time))
+	return nil
+}
+
+func (state *State) appendOutput(queued queuedEvent, at time.Time) {
+	if last := state.outputTime(at); last.After(at) {
+		at = last
+	}
+	queued.ev.Time = timeToSyscallTimeval(at)
+	state.outputQueue = append(state.outputQueue, queued)
+	state.outputDeadline = syscallTimevalToTime(state.outputQueue[0].ev.Time)
+}
+
+// afterOutputTimer writes the queued events which are due at now.
+func (state *State) afterOutputTimer(now time.Time) error {
+	for len(state.outputQueue) > 0 {
+		queued := state.outputQueue[0]
+		if syscallTimevalToTime(queued.ev.Time).After(now) {
+			break
+		}
+		state.outputQueue = state.outputQueue[1:]
+		fmt.Printf("  write %s (queued)\n", eventToString(&queued.ev, state.config.Layout))
+		var err error
+		if queued.input {
+			err = queued.ew.WriteOne(&queued.ev)
+		} else {
+			err = writeOut(queued.ew, queued.ev)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	state.outputDeadline = maxTime
+	if len(state.outputQueue) > 0 {
+		state.outputDeadline = syscallTimevalToTime(state.outputQueue[0].ev.Time)
+	}
+	return nil
+}
+
+func reversed(keys []KeyCode) []KeyCode {
+	ret := slices.Clone(keys)
+	slices.Reverse(ret)
+	return ret
+}
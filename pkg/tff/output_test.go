@@ -0,0 +1,147 @@
+package tff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_stringToOutput(t *testing.T) {
+	outKeys, output, err := stringToOutput("leftctrl c")
+	require.NoError(t, err)
+	require.Equal(t, []KeyCode{evdev.KEY_LEFTCTRL, evdev.KEY_C}, outKeys)
+	require.Nil(t, output)
+
+	outKeys, output, err = stringToOutput("ctrl+shift+t, 20ms, 0")
+	require.NoError(t, err)
+	require.Equal(t, []KeyCode{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTSHIFT, evdev.KEY_T, evdev.KEY_0}, outKeys)
+	require.Equal(t, []OutputStep{
+		{Keys: []KeyCode{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTSHIFT, evdev.KEY_T}},
+		{Delay: 20 * time.Millisecond},
+		{Keys: []KeyCode{evdev.KEY_0}},
+	}, output)
+
+	_, _, err = stringToOutput("ctrl+a, -5ms")
+	require.ErrorContains(t, err, "negative delay")
+}
+
+var outputYaml = `
+outputPacing: 1ms
+combos:
+  - keys: f j
+    outKeys: g, g
+  - keys: j f
+    outKeys: ctrl+a, 20ms, delete
+    pacing: 2ms
+sequences:
+  - keys: capslock t
+    outKeys: ctrl+shift+t, x
+`
+
+func Test_Output_KeySequence(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (50ms) j_ (150ms) j/ (50ms) f/
+	`,
+		`
+		G-down
+		G-up
+		G-down
+		G-up
+	`,
+		outputYaml)
+}
+
+func Test_Output_ChordAndDelay(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		j_ (50ms) f_ (150ms) f/ (50ms) j/
+	`,
+		`
+		LEFTCTRL-down
+		A-down
+		A-up
+		LEFTCTRL-up
+		DELETE-down
+		DELETE-up
+	`,
+		outputYaml)
+}
+
+func Test_Output_DelayDoesNotBlock(t *testing.T) {
+	// The delay gets queued. Keys which get pressed during the delay get written after it.
+	config, err := LoadYamlFromBytes([]byte(`
+combos:
+  - keys: f j
+    outKeys: ctrl+a, 100ms, delete
+`))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString("f_ (50ms) j_ (150ms) j/ (10ms) f/ (20ms) x_ (10ms) x/")
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	require.NoError(t, manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil))
+	ew.requireEqual(t, `
+		LEFTCTRL-down
+		A-down
+		A-up
+		LEFTCTRL-up
+		DELETE-down
+		DELETE-up
+		X-down
+		X-up
+	`)
+	var times []time.Time
+	for _, ev := range ew.s {
+		if ev.Type == evdev.EV_KEY {
+			times = append(times, syscallTimevalToTime(ev.Time))
+		}
+	}
+	require.Equal(t, 100*time.Millisecond, times[4].Sub(times[3]))
+	require.False(t, times[6].Before(times[5]))
+}
+
+func Test_Output_MotionWaitsBehindQueue(t *testing.T) {
+	// A motion of the mouse must not overtake the queued keys.
+	config, err := LoadYamlFromBytes([]byte(`
+combos:
+  - keys: f j
+    outKeys: x
+`))
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	state := NewState(config, &ew)
+	now := time.Now()
+	state.queueOutput(&ew, Event{Type: evdev.EV_KEY, Code: evdev.KEY_DELETE, Value: DOWN}, now.Add(100*time.Millisecond))
+	motion := Event{Time: timeToSyscallTimeval(now), Type: evdev.EV_REL, Code: evdev.REL_Y, Value: 3}
+	require.NoError(t, manInTheMiddleInnerLoop(&motion, &ew, state))
+	require.Empty(t, ew.s)
+	require.NoError(t, state.afterOutputTimer(maxTime))
+	var actual []string
+	for _, ev := range ew.s {
+		if !eventToSkip(&ev) {
+			actual = append(actual, eventToString(&ev, nil))
+		}
+	}
+	require.Equal(t, []string{"delete_", "y+3"}, actual)
+}
+
+func Test_Output_Sequence(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (200ms) t_ (50ms) t/
+	`,
+		`
+		LEFTCTRL-down
+		LEFTSHIFT-down
+		T-down
+		T-up
+		LEFTSHIFT-up
+		LEFTCTRL-up
+		X-down
+		X-up
+	`,
+		outputYaml)
+}
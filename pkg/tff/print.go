@@ -5,13 +5,19 @@ import (
 	"os"
 )
 
-func PrintMain(path string) error {
+// PrintMain prints the events of the device. If layoutName is set, keys get shown with the
+// characters of the keycaps.
+func PrintMain(path, layoutName string) error {
+	layout, err := layoutByName(layoutName)
+	if err != nil {
+		return err
+	}
 	sourceDev, err := GetDeviceFromPath(path)
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
-	err = printEvents(sourceDev)
+	err = printEvents(sourceDev, layout)
 	if err != nil {
 		fmt.Println(err.Error())
 	}
@@ -0,0 +1,167 @@
+package tff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadPollInterval: how often the modification time of combos.yaml gets checked.
+const reloadPollInterval = time.Second
+
+// reloader loads combos.yaml again on SIGHUP, and when the file was modified. If the new config
+// is invalid, the old config stays active.
+type reloader struct {
+	// mu: reload gets called by run and by the control socket.
+	mu sync.Mutex
+
+	file string
+
+	// config is the config which was loaded last.
+	config *Config
+
+	modTime time.Time
+	size    int64
+
+	// Each engine gets its own channel. The channels have a buffer of one. If an engine has not
+	// taken the previous config yet, it gets replaced by the new config.
+	subscribers []chan *Config
+}
+
+func newReloader(file string, config *Config) *reloader {
+	r := reloader{
+		file:   file,
+		config: config,
+	}
+	r.modTime, r.size = fileModTimeAndSize(file)
+	return &r
+}
+
+func fileModTimeAndSize(file string) (time.Time, int64) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return fi.ModTime(), fi.Size()
+}
+
+// subscribe must be called before run.
+func (r *reloader) subscribe() <-chan *Config {
+	ch := make(chan *Config, 1)
+	r.subscribers = append(r.subscribers, ch)
+	return ch
+}
+
+func (r *reloader) run(ctx context.Context) {
+	sigChannel := make(chan os.Signal, 1)
+	signal.Notify(sigChannel, syscall.SIGHUP)
+	defer signal.Stop(sigChannel)
+	ticker := time.NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigChannel:
+			_ = r.reload("SIGHUP")
+		case <-ticker.C:
+			if r.fileChanged() {
+				_ = r.reload("file changed")
+			}
+		}
+	}
+}
+
+// fileChanged returns true, if the modification time or the size changed since the last call.
+func (r *reloader) fileChanged() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTime, size := fileModTimeAndSize(r.file)
+	if modTime.Equal(r.modTime) && size == r.size {
+		return false
+	}
+	r.modTime, r.size = modTime, size
+	return true
+}
+
+// reload loads the config, and passes it to the engines. If the new config is invalid, the
+// old config stays active.
+func (r *reloader) reload(reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config, err := r.load()
+	if err != nil {
+		fmt.Printf("Reload of %q (%s) failed. Keeping the old config: %v\n", r.file, reason, err)
+		return fmt.Errorf("reload failed. Keeping the old config: %w", err)
+	}
+	fmt.Printf("Reload of %q (%s): new config loaded\n", r.file, reason)
+	printFindings(r.file, config.Findings)
+	r.config = config
+	for _, ch := range r.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- config
+	}
+	return nil
+}
+
+func (r *reloader) load() (*Config, error) {
+	config, err := LoadYamlFile(r.file)
+	if err != nil {
+		return nil, err
+	}
+	if config.isEmpty() {
+		return nil, errors.New("No combo contains keys")
+	}
+	if !devicesEqual(config.Devices, r.config.Devices) {
+		// The devices get opened and grabbed once at start.
+		return nil, errors.New("changes of 'devices' need a restart")
+	}
+	return config, nil
+}
+
+// devicesEqual compares the names and paths. 'block' of a device can be changed by a reload.
+func devicesEqual(a, b []DeviceConfig) bool {
+	return slices.EqualFunc(a, b, func(x, y DeviceConfig) bool {
+		return x.Name == y.Name && x.Path == y.Path
+	})
+}
+
+// idle returns true, if no key is pending or held by the engine. Only then a new config can be
+// used, because pending keys and held combos refer to the old config.
+func (state *State) idle() bool {
+	return len(state.buf) == 0 &&
+		len(state.downKeysWritten) == 0 &&
+		len(state.pressedRemaps) == 0 &&
+		len(state.swallowKeys) == 0 &&
+		len(state.consumedModifiers) == 0 &&
+		len(state.sequenceSwallowUps) == 0 &&
+		len(state.heldMouseActions) == 0 &&
+		len(state.outputQueue) == 0 &&
+		len(state.trainingDelayed) == 0 &&
+		state.tapHold == nil &&
+		state.sequence == nil
+}
+
+// setConfig switches to a new config. Must only be called, if the state is idle.
+func (state *State) setConfig(config *Config) {
+	state.config = config
+	state.layerStack = []*Layer{config.BaseLayer()}
+	state.combosLayer = config.BaseLayer()
+	state.timing = config.Timing.withDefaults(DefaultTiming)
+	state.scanCodesOfConfig = config.scanCodes()
+	state.buttonsOfConfig = config.buttons()
+	state.trainingKeys = config.trainingKeys()
+	if !config.Repeat.enabled() {
+		state.repeatingKey = 0
+		state.stopRepeatTimer()
+	}
+}
@@ -0,0 +1,104 @@
+package tff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// reloadingReader passes config to the engine, before it returns the event with the index at.
+// The channel is unbuffered, so the engine has received the config before it gets the event.
+type reloadingReader struct {
+	*readFromSlice
+	at      int
+	n       int
+	config  *Config
+	configs chan *Config
+}
+
+func (r *reloadingReader) ReadOne() (*Event, error) {
+	if r.n == r.at {
+		r.configs <- r.config
+	}
+	r.n++
+	return r.readFromSlice.ReadOne()
+}
+
+func assertReload(t *testing.T, input string, at int, expected string) {
+	t.Helper()
+	oldConfig, err := LoadYamlFromBytes([]byte("combos:\n  - keys: f j\n    outKeys: x\n"))
+	require.NoError(t, err)
+	newConfig, err := LoadYamlFromBytes([]byte("combos:\n  - keys: f j\n    outKeys: y\n"))
+	require.NoError(t, err)
+	rfs, err := NewReadFromSliceInputStateString(input)
+	require.NoError(t, err)
+	er := &reloadingReader{readFromSlice: rfs, at: at, config: newConfig, configs: make(chan *Config)}
+	ew := writeToSlice{}
+	err = manInTheMiddle(context.Background(), er, &ew, oldConfig, VirtualClock{}, &engineControl{configs: er.configs})
+	require.NoError(t, err)
+	ew.requireEqual(t, expected)
+}
+
+func Test_Reload_Idle(t *testing.T) {
+	assertReload(t, "a_ (20ms) a/ (500ms) f_ (20ms) j_ (200ms) j/ (20ms) f/", 2, `
+		A-down
+		A-up
+		Y-down
+		Y-up
+		`)
+}
+
+func Test_Reload_WhileComboHeld(t *testing.T) {
+	// The new config gets used, after the combo was released.
+	assertReload(t, "f_ (20ms) j_ (200ms) j/ (20ms) f/ (500ms) f_ (20ms) j_ (200ms) j/ (20ms) f/", 2, `
+		X-down
+		X-up
+		Y-down
+		Y-up
+		`)
+}
+
+func Test_reloader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "combos.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	}
+	write("combos:\n  - keys: f j\n    outKeys: x\n")
+	config, err := LoadYamlFile(file)
+	require.NoError(t, err)
+	r := newReloader(file, config)
+	configs := r.subscribe()
+	require.False(t, r.fileChanged())
+
+	// Invalid config: the old config stays.
+	write("combos:\n  - keys: f j\n    outKeys: unknown-key\n")
+	require.True(t, r.fileChanged())
+	r.reload("test")
+	require.Empty(t, configs)
+	require.Same(t, config, r.config)
+
+	// 'devices' can not be changed.
+	write("devices:\n  - name: pedal\n    path: /dev/input/event3\ncombos:\n  - keys: f j\n    outKeys: y\n")
+	r.reload("test")
+	require.Empty(t, configs)
+
+	// Only the latest config gets passed to the engine.
+	write("combos:\n  - keys: f j\n    outKeys: y\n")
+	r.reload("test")
+	write("combos:\n  - keys: f j\n    outKeys: z\n")
+	r.reload("test")
+	require.Len(t, configs, 1)
+	newConfig := <-configs
+	require.Same(t, r.config, newConfig)
+	require.Equal(t, "KEY_F KEY_J -> KEY_Z", newConfig.BaseLayer().Combos[0].String())
+
+	// A change of the modification time gets detected.
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(file, future, future))
+	require.True(t, r.fileChanged())
+	require.False(t, r.fileChanged())
+}
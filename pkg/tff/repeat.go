@@ -0,0 +1,132 @@
+package tff
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// Repeat configures the autorepeat of held keys. If both values are zero, the repeat events of
+// the kernel get forwarded. Otherwise tff creates the repeat events itself.
+type Repeat struct {
+	// Delay: the first repeat event gets written Delay after the key was pressed.
+	Delay time.Duration
+
+	// Interval between two repeat events.
+	Interval time.Duration
+}
+
+func (r Repeat) enabled() bool {
+	return r.Delay > 0 && r.Interval > 0
+}
+
+// repeatKey returns the key which gets repeated while the combo is held: the last key of the
+// last chord. Zero means: no repeat.
+func (c *Combo) repeatKey() KeyCode {
+	if c.Text != "" {
+		// The text gets typed once.
+		return 0
+	}
+	if c.Output != nil {
+		keys := c.Output[len(c.Output)-1].Keys
+		if len(keys) == 0 {
+			// The last step is a delay.
+			return 0
+		}
+		return keys[len(keys)-1]
+	}
+	if len(c.OutKeys) == 0 {
+		// Only a command.
+		return 0
+	}
+	return c.OutKeys[len(c.OutKeys)-1]
+}
+
+// repeatOutKey returns the key which gets repeated, while the physical key is held.
+// ok is false, if nothing should be repeated. For example, because the key is still in the
+// buffer, and it is not decided yet if it is part of a combo.
+func (state *State) repeatOutKey(key KeyCode) (outKey KeyCode, ok bool) {
+	if state.tapHold != nil || state.sequence != nil {
+		return 0, false
+	}
+	outKey = key
+	inCombo := false
+	for _, combo := range state.downKeysWritten {
+		if slices.Contains(combo.Keys, key) {
+			outKey = combo.repeatKey()
+			inCombo = true
+			break
+		}
+	}
+	if !inCombo {
+		if slices.ContainsFunc(state.buf, func(ev Event) bool { return ev.Code == key }) {
+			return 0, false
+		}
+		if remap, ok := state.pressedRemaps[key]; ok {
+			if remap.OutKey == 0 {
+				// Layer action or tap-hold.
+				return 0, false
+			}
+			outKey = remap.OutKey
+		}
+	}
+	if outKey == 0 || !state.keysDown[outKey] {
+		return 0, false
+	}
+	return outKey, true
+}
+
+// HandleRepeat handles a repeat event of the kernel.
+func (state *State) HandleRepeat(ev Event) error {
+	if state.config.Repeat.enabled() {
+		fmt.Printf(" skipping (repeat): tff creates repeat events itself\n")
+		return nil
+	}
+	outKey, ok := state.repeatOutKey(ev.Code)
+	if !ok {
+		fmt.Printf(" skipping (repeat): %s\n", ev.String())
+		return nil
+	}
+	ev.Code = outKey
+	return state.WriteEvent(ev, "Repeat")
+}
+
+// trackRepeat remembers the key which was pressed last. Like the kernel, only this key gets
+// repeated. Only used, if the repeat values are configured.
+func (state *State) trackRepeat(ev Event) {
+	if !state.config.Repeat.enabled() {
+		return
+	}
+	switch ev.Value {
+	case DOWN:
+		state.repeatingKey = ev.Code
+		state.startRepeatTimer(syscallTimevalToTime(ev.Time), state.config.Repeat.Delay)
+	case UP:
+		if ev.Code == state.repeatingKey {
+			state.repeatingKey = 0
+			state.stopRepeatTimer()
+		}
+	}
+}
+
+// afterRepeatTimer writes a repeat event for the key which was pressed last.
+func (state *State) afterRepeatTimer(t time.Time) error {
+	if state.repeatingKey == 0 {
+		return nil
+	}
+	// Start the timer again, even if nothing gets written now. A key which is still in the
+	// buffer repeats, once it got flushed.
+	state.startRepeatTimer(t, state.config.Repeat.Interval)
+	outKey, ok := state.repeatOutKey(state.repeatingKey)
+	if !ok {
+		return nil
+	}
+	return state.WriteEvent(Event{
+		Time:  timeToSyscallTimeval(t),
+		Type:  evdev.EV_KEY,
+		Code:  outKey,
+		Value: REPEAT,
+	}, "RepeatTimer")
+}
@@ -0,0 +1,108 @@
+package tff
+
+import (
+	"testing"
+)
+
+var repeatYaml = `
+remaps:
+  - key: capslock
+    outKey: esc
+combos:
+  - keys: f j
+    outKeys: down
+  - keys: j k
+    outKeys: ctrl+a, 20ms
+`
+
+func Test_Repeat_PassThrough(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		a_ (300ms) a= (30ms) a= (30ms) a/
+	`,
+		`
+		A-down
+		A-repeat
+		A-repeat
+		A-up
+	`,
+		repeatYaml)
+}
+
+func Test_Repeat_Remap(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (300ms) capslock= (30ms) capslock/
+	`,
+		`
+		ESC-down
+		ESC-repeat
+		ESC-up
+	`,
+		repeatYaml)
+}
+
+func Test_Repeat_Combo(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (50ms) j_ (300ms) j= (30ms) j= (50ms) j/ (10ms) f/
+	`,
+		`
+		DOWN-down
+		DOWN-repeat
+		DOWN-repeat
+		DOWN-up
+	`,
+		repeatYaml)
+}
+
+func Test_Repeat_ComboEndsWithDelay(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		j_ (50ms) k_ (300ms) k= (30ms) k/ (10ms) j/
+	`,
+		`
+		LEFTCTRL-down
+		A-down
+		A-up
+		LEFTCTRL-up
+	`,
+		repeatYaml)
+}
+
+func Test_Repeat_BufferedKey(t *testing.T) {
+	// The key is in the buffer. It is not decided yet if it is part of a combo.
+	// The repeat events must not be written before the down event.
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (300ms) f= (30ms) f= (30ms) f/
+	`,
+		`
+		F-down
+		F-up
+	`,
+		repeatYaml)
+}
+
+func Test_Repeat_Configured(t *testing.T) {
+	// The repeat events of the kernel get ignored. tff creates them itself.
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (50ms) j_ (250ms) j= (80ms) j/ (10ms) f/
+	`,
+		`
+		DOWN-down
+		DOWN-repeat
+		DOWN-repeat
+		DOWN-repeat
+		DOWN-up
+	`,
+		`
+repeat:
+  delay: 200ms
+  interval: 50ms
+combos:
+  - keys: f j
+    outKeys: down
+`)
+}
@@ -20,7 +20,7 @@ func ReplayComboLogMain(ctx context.Context, comboYamlFile string, logFile strin
 		return err
 	}
 	defer outDev.Close()
-	combos, err := LoadYamlFile(comboYamlFile)
+	config, err := LoadYamlFile(comboYamlFile)
 	if err != nil {
 		return fmt.Errorf("failed to load %q: %w", comboYamlFile, err)
 	}
@@ -33,5 +33,5 @@ func ReplayComboLogMain(ctx context.Context, comboYamlFile string, logFile strin
 	scanner := bufio.NewScanner(file)
 	logReader := ComboLogEventReader{scanner: scanner}
 
-	return manInTheMiddle(ctx, &logReader, outDev, combos, false)
+	return manInTheMiddle(ctx, &logReader, outDev, config, VirtualClock{}, nil)
 }
@@ -0,0 +1,138 @@
+package tff
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	RunOnDown = "down"
+	RunOnUp   = "up"
+)
+
+// DefaultRunTimeout is used, if no timeout is configured for a command.
+const DefaultRunTimeout = time.Minute
+
+// RunAction executes a shell command, when a combo gets pressed or released.
+type RunAction struct {
+	// Command gets executed via "/bin/sh -c".
+	Command string
+
+	// On is RunOnDown or RunOnUp.
+	On string
+
+	// The command gets killed after Timeout.
+	Timeout time.Duration
+
+	// User: the command runs as this user. Empty means: the user running tff.
+	User string
+
+	// Env gets added to the environment of the command.
+	Env map[string]string
+}
+
+func (r *RunAction) String() string {
+	return fmt.Sprintf("run %q", r.Command)
+}
+
+// CommandRunner executes the command of a RunAction. Run must not block, because it gets called
+// from the event loop.
+type CommandRunner interface {
+	Run(action *RunAction)
+}
+
+// execRunner executes the commands in a goroutine, and logs the output.
+type execRunner struct{}
+
+var _ CommandRunner = execRunner{}
+
+func (execRunner) Run(action *RunAction) {
+	go func() {
+		start := time.Now()
+		output, err := runCommand(action)
+		fmt.Printf("  %s finished after %s. err: %v\n%s", action.String(),
+			time.Since(start).Round(time.Millisecond), err, output)
+	}()
+}
+
+func runCommand(action *RunAction) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), action.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", action.Command)
+	// The command gets its own process group. On timeout, the whole group gets killed.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Commands like "setsid -f firefox" keep stdout open. Do not wait for them.
+	cmd.WaitDelay = time.Second
+	cmd.Env = os.Environ()
+	if action.User != "" {
+		u, err := user.Lookup(action.User)
+		if err != nil {
+			return nil, err
+		}
+		credential, err := userCredential(u)
+		if err != nil {
+			return nil, err
+		}
+		cmd.SysProcAttr.Credential = credential
+		cmd.Dir = u.HomeDir
+		cmd.Env = []string{
+			"PATH=" + os.Getenv("PATH"),
+			"HOME=" + u.HomeDir,
+			"USER=" + u.Username,
+			"LOGNAME=" + u.Username,
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(action.Env)) {
+		cmd.Env = append(cmd.Env, key+"="+action.Env[key])
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	return output.Bytes(), err
+}
+
+func userCredential(u *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		g, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(g))
+	}
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}, nil
+}
+
+// runCombo starts the command of the combo, if the combo has one.
+func (state *State) runCombo(combo *Combo, value upDownValue) {
+	if combo.Run == nil || combo.Run.On != eventValueToString[value] {
+		return
+	}
+	fmt.Printf("  %s %s\n", combo.Run.String(), eventValueToString[value])
+	state.runner.Run(combo.Run)
+}
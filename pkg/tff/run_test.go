@@ -0,0 +1,92 @@
+package tff
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRunner struct {
+	commands []string
+}
+
+func (r *fakeRunner) Run(action *RunAction) {
+	r.commands = append(r.commands, action.Command)
+}
+
+var _ CommandRunner = &fakeRunner{}
+
+func Test_Run_Combo(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+runDefaults:
+  user: nobody
+  env:
+    DISPLAY: ":0"
+combos:
+  - keys: f j
+    run:
+      command: gnome-terminal
+  - keys: j f
+    outKeys: x
+    run:
+      command: notify-send released
+      on: up
+      env:
+        DISPLAY: ":1"
+`))
+	require.NoError(t, err)
+	run := config.BaseLayer().Combos[1].Run
+	require.Equal(t, "nobody", run.User)
+	require.Equal(t, DefaultRunTimeout, run.Timeout)
+	require.Equal(t, map[string]string{"DISPLAY": ":1"}, run.Env)
+
+	ew := writeToSlice{}
+	state := NewState(config, &ew)
+	runner := &fakeRunner{}
+	state.runner = runner
+
+	start := time.Unix(1712500000, 0)
+	at := func(d time.Duration) syscall.Timeval {
+		return timeToSyscallTimeval(start.Add(d))
+	}
+	press := func(key KeyCode, value int32, d time.Duration) {
+		require.NoError(t, state.HandleKey(Event{Time: at(d), Type: evdev.EV_KEY, Code: key, Value: value}))
+	}
+	press(evdev.KEY_F, DOWN, 0)
+	press(evdev.KEY_J, DOWN, 50*time.Millisecond)
+	press(evdev.KEY_J, UP, 250*time.Millisecond)
+	press(evdev.KEY_F, UP, 260*time.Millisecond)
+	require.Equal(t, []string{"gnome-terminal"}, runner.commands)
+	require.Empty(t, ew.s)
+
+	press(evdev.KEY_J, DOWN, 500*time.Millisecond)
+	press(evdev.KEY_F, DOWN, 550*time.Millisecond)
+	press(evdev.KEY_F, UP, 750*time.Millisecond)
+	press(evdev.KEY_J, UP, 760*time.Millisecond)
+	require.Equal(t, []string{"gnome-terminal", "notify-send released"}, runner.commands)
+	ew.requireEqual(t, `
+		X-down
+		X-up
+	`)
+}
+
+func Test_runCommand(t *testing.T) {
+	output, err := runCommand(&RunAction{
+		Command: `echo "$GREETING"`,
+		Timeout: time.Second,
+		Env:     map[string]string{"GREETING": "hello"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(output))
+
+	start := time.Now()
+	_, err = runCommand(&RunAction{
+		Command: "sleep 10",
+		Timeout: 50 * time.Millisecond,
+	})
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
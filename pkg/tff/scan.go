@@ -0,0 +1,180 @@
+package tff
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// Keys can be given by scan code, for example "scan:0x70009". This is useful, if a keyboard
+// reports the same key code for different physical keys, or KEY_UNKNOWN.
+//
+// Each scan code which is used in combos.yaml gets a synthetic key code above KEY_MAX. The engine
+// uses the synthetic key code, if an EV_KEY event has this scan code. When the key gets written,
+// the original key code gets used again.
+
+const scanKeyPrefix = "scan:"
+
+// firstScanKeyCode is the first synthetic key code.
+const firstScanKeyCode = KeyCode(evdev.KEY_MAX + 1)
+
+// scanRegistry contains the synthetic key codes. The codes never change while tff is running,
+// so that a reload can not change the meaning of a key which is held.
+var scanRegistry = struct {
+	sync.Mutex
+	keys      map[uint32]KeyCode
+	scanCodes map[KeyCode]uint32
+}{
+	keys:      make(map[uint32]KeyCode),
+	scanCodes: make(map[KeyCode]uint32),
+}
+
+// scanCodeToKeyCode returns the synthetic key code of the scan code.
+func scanCodeToKeyCode(scanCode uint32) (KeyCode, error) {
+	scanRegistry.Lock()
+	defer scanRegistry.Unlock()
+	if key, ok := scanRegistry.keys[scanCode]; ok {
+		return key, nil
+	}
+	n := len(scanRegistry.keys)
+	if n > math.MaxUint16-int(firstScanKeyCode) {
+		return 0, fmt.Errorf("too many scan codes")
+	}
+	key := firstScanKeyCode + KeyCode(n)
+	scanRegistry.keys[scanCode] = key
+	scanRegistry.scanCodes[key] = scanCode
+	return key, nil
+}
+
+// keyToScanCode returns the scan code, if key is a synthetic key code.
+func keyToScanCode(key KeyCode) (uint32, bool) {
+	if key < firstScanKeyCode {
+		return 0, false
+	}
+	scanRegistry.Lock()
+	defer scanRegistry.Unlock()
+	scanCode, ok := scanRegistry.scanCodes[key]
+	return scanCode, ok
+}
+
+func isScanKey(key KeyCode) bool {
+	_, ok := keyToScanCode(key)
+	return ok
+}
+
+func scanCodeName(scanCode uint32) string {
+	return fmt.Sprintf("%s0x%x", scanKeyPrefix, scanCode)
+}
+
+// wordToScanKey parses words like "scan:0x70009".
+func wordToScanKey(s string) (KeyCode, error) {
+	scanCode, err := strconv.ParseUint(strings.TrimPrefix(s, scanKeyPrefix), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scan code %q. Example: scan:0x70009", s)
+	}
+	return scanCodeToKeyCode(uint32(scanCode))
+}
+
+// keyCodeName returns the name of the evdev code (like KEY_A or BTN_LEFT), or the name of the
+// scan code.
+func keyCodeName(key KeyCode) string {
+	if scanCode, ok := keyToScanCode(key); ok {
+		return scanCodeName(scanCode)
+	}
+	return buttonName(evdev.CodeName(evdev.EV_KEY, key))
+}
+
+// noScanKeys returns an error, if the keys contain a scan code. Scan codes can only be used for
+// keys which get pressed, not for keys which get written.
+func noScanKeys(keys ...KeyCode) error {
+	for _, key := range keys {
+		if scanCode, ok := keyToScanCode(key); ok {
+			return fmt.Errorf("%q: scan codes can not be written. Use the name of the key", scanCodeName(scanCode))
+		}
+	}
+	return nil
+}
+
+// scanCodes returns the scan codes which are used in the config.
+func (c *Config) scanCodes() map[uint32]KeyCode {
+	codes := make(map[uint32]KeyCode)
+	add := func(keys ...KeyCode) {
+		for _, key := range keys {
+			if scanCode, ok := keyToScanCode(key); ok {
+				codes[scanCode] = key
+			}
+		}
+	}
+	add(c.Block...)
+	for _, d := range c.Devices {
+		add(d.Block...)
+	}
+	for _, layer := range c.Layers {
+		for key := range layer.Remaps {
+			add(key)
+		}
+		for _, combo := range layer.Combos {
+			add(combo.Keys...)
+			for _, keys := range combo.Modifiers {
+				add(keys...)
+			}
+		}
+		for _, seq := range layer.Sequences {
+			add(seq.Keys...)
+		}
+	}
+	return codes
+}
+
+// applyScanCode correlates MSC_SCAN with the EV_KEY event of the same SYN frame. If the scan
+// code is used in the config, the key code gets replaced by the synthetic key code.
+func (state *State) applyScanCode(ev *Event, device string) {
+	switch {
+	case ev.Type == evdev.EV_MSC && ev.Code == evdev.MSC_SCAN:
+		state.pendingScanCodes[device] = uint32(ev.Value)
+		return
+	case ev.Type == evdev.EV_SYN:
+		delete(state.pendingScanCodes, device)
+		return
+	case ev.Type != evdev.EV_KEY:
+		return
+	}
+	held := scanKeyOfDevice{ev.Code, device}
+	scanCode, ok := state.pendingScanCodes[device]
+	if !ok {
+		// Autorepeat of the kernel has no scan code.
+		if key, ok := state.scanKeysDown[held]; ok {
+			ev.Code = key
+		}
+		return
+	}
+	key, ok := state.scanCodesOfConfig[scanCode]
+	if !ok {
+		return
+	}
+	switch ev.Value {
+	case DOWN:
+		state.scanKeysDown[held] = key
+	case UP:
+		delete(state.scanKeysDown, held)
+	}
+	state.scanOriginalKeys[key] = ev.Code
+	ev.Code = key
+}
+
+type scanKeyOfDevice struct {
+	key    KeyCode
+	device string
+}
+
+// originalKey returns the key code which was read from the device, if key is a synthetic key code.
+func (state *State) originalKey(key KeyCode) KeyCode {
+	if original, ok := state.scanOriginalKeys[key]; ok {
+		return original
+	}
+	return key
+}
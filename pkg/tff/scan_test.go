@@ -0,0 +1,98 @@
+package tff
+
+import (
+	"context"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+// stateStringToFrames converts the state string to SYN frames. Each key event gets the scan
+// code of the same index. Zero means: no MSC_SCAN event (like the autorepeat of the kernel).
+func stateStringToFrames(t *testing.T, stateString string, scanCodes ...uint32) *readFromSlice {
+	t.Helper()
+	events, err := stateStringToSlice(stateString)
+	require.NoError(t, err)
+	require.Len(t, scanCodes, len(events))
+	var frames []evdev.InputEvent
+	for i, ev := range events {
+		if scanCodes[i] != 0 {
+			frames = append(frames, Event{Time: ev.Time, Type: evdev.EV_MSC, Code: evdev.MSC_SCAN, Value: int32(scanCodes[i])})
+		}
+		frames = append(frames, ev, Event{Time: ev.Time, Type: evdev.EV_SYN, Code: evdev.SYN_REPORT})
+	}
+	return &readFromSlice{s: frames}
+}
+
+const scanYaml = `
+remaps:
+  - key: scan:0x70064
+    outKey: leftctrl
+combos:
+  - keys: scan:0x70009 j
+    outKeys: x
+`
+
+func Test_ScanCode(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(scanYaml))
+	require.NoError(t, err)
+	require.Equal(t, "scan:0x70009 KEY_J -> KEY_X", config.BaseLayer().Combos[0].String())
+
+	er := stateStringToFrames(t,
+		"f_ (20ms) j_ (200ms) j/ (20ms) f/ (200ms) f_ (20ms) f/ (200ms) 102nd_ (20ms) 102nd/ (200ms) 102nd_ (20ms) 102nd/",
+		0x70009, 0x7000d, 0x7000d, 0x70009, 0x70009, 0x70009, 0x70064, 0x70064, 0x70031, 0x70031)
+	ew := writeToSlice{}
+	require.NoError(t, manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil))
+
+	// The synthetic key codes get written with the original key code. Only the key 102nd with
+	// the scan code 0x70064 gets remapped.
+	ew.requireEqual(t, `
+		X-down
+		X-up
+		F-down
+		F-up
+		LEFTCTRL-down
+		LEFTCTRL-up
+		102ND-down
+		102ND-up
+		`)
+}
+
+func Test_ScanCode_Repeat(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(scanYaml))
+	require.NoError(t, err)
+	state := NewState(config, &writeToSlice{})
+	key, err := scanCodeToKeyCode(0x70064)
+	require.NoError(t, err)
+
+	var codes []KeyCode
+	for _, ev := range stateStringToFrames(t, "102nd_ (20ms) 102nd= (20ms) 102nd/ (20ms) 102nd=", 0x70064, 0, 0x70064, 0).s {
+		state.applyScanCode(&ev, "")
+		if ev.Type == evdev.EV_KEY {
+			codes = append(codes, ev.Code)
+		}
+	}
+	// The autorepeat has no scan code. It gets the synthetic key code while the key is down.
+	require.Equal(t, []KeyCode{key, key, key, evdev.KEY_102ND}, codes)
+	require.Equal(t, KeyCode(evdev.KEY_102ND), state.originalKey(key))
+}
+
+func Test_ScanCode_LoadYaml(t *testing.T) {
+	_, err := LoadYamlFromBytes([]byte("remaps:\n  - key: scan:0xzz\n    outKey: a\n"))
+	require.ErrorContains(t, err, `invalid scan code "scan:0xzz". Example: scan:0x70009`)
+
+	_, err = LoadYamlFromBytes([]byte("remaps:\n  - key: a\n    outKey: scan:0x70004\n"))
+	require.ErrorContains(t, err, `remap of "a": 'outKey': "scan:0x70004": scan codes can not be written.`)
+
+	_, err = LoadYamlFromBytes([]byte("combos:\n  - keys: f j\n    outKeys: scan:0x70004\n"))
+	require.ErrorContains(t, err, `"scan:0x70004": scan codes can not be written.`)
+
+	// The same scan code gets the same synthetic key code.
+	a, err := wordToKeyCode("scan:0x70004")
+	require.NoError(t, err)
+	b, err := wordToKeyCode("scan:458756")
+	require.NoError(t, err)
+	require.Equal(t, a, b)
+	require.Greater(t, a, KeyCode(evdev.KEY_MAX))
+}
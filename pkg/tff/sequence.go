@@ -0,0 +1,188 @@
+package tff
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// DefaultSequenceTimeout is used if a sequence has no timeout.
+const DefaultSequenceTimeout = time.Second
+
+// Sequence is triggered by keys which get tapped one after the other (vim-style leader
+// sequences). In contrast to combos, the keys do not overlap.
+type Sequence struct {
+	Combo
+
+	// Timeout: the sequence gets canceled, if the next key does not get pressed within Timeout.
+	Timeout time.Duration
+}
+
+// pendingSequence contains the keys of a sequence which is in progress.
+type pendingSequence struct {
+	keys []KeyCode
+
+	// The swallowed events. They get written, if the sequence gets canceled.
+	events []Event
+
+	timeout time.Duration
+}
+
+// startsSequence returns true if the key is the first key of a sequence of the active layer.
+func (state *State) startsSequence(key KeyCode) bool {
+	for _, seq := range state.activeLayer().Sequences {
+		if seq.Keys[0] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingSequences returns the sequences of the active layer which start with keys.
+func (state *State) matchingSequences(keys []KeyCode) []*Sequence {
+	var ret []*Sequence
+	for _, seq := range state.activeLayer().Sequences {
+		if len(seq.Keys) >= len(keys) && slices.Equal(seq.Keys[:len(keys)], keys) {
+			ret = append(ret, seq)
+		}
+	}
+	return ret
+}
+
+// handleSequenceKey gets called for keys which are not remapped. If it returns false, the
+// event was not handled, and it needs to be passed to the combo engine.
+func (state *State) handleSequenceKey(ev Event) (bool, error) {
+	p := state.sequence
+	if ev.Value == UP {
+		if i := slices.Index(state.sequenceSwallowUps, ev.Code); i != -1 {
+			// Key of a finished sequence got released.
+			state.sequenceSwallowUps = slices.Delete(state.sequenceSwallowUps, i, i+1)
+			return true, nil
+		}
+		if p == nil {
+			return state.handleLeaderTap(ev), nil
+		}
+		if slices.Contains(p.keys, ev.Code) {
+			p.events = append(p.events, ev)
+			return true, nil
+		}
+		// A key which was pressed before the sequence started. The buffer is empty
+		// while a sequence is in progress, so it can be written directly.
+		return true, state.WriteEvent(ev, "Sequence>unrelated-up")
+	}
+
+	if p == nil {
+		// A leader which is part of a combo gets passed to the combo engine. The sequence
+		// starts, if the leader gets tapped (see handleLeaderTap).
+		if len(state.buf) > 0 || !state.startsSequence(ev.Code) || state.usedInCombos(ev.Code) {
+			return false, nil
+		}
+		p = &pendingSequence{}
+		state.sequence = p
+	}
+
+	keys := append(slices.Clone(p.keys), ev.Code)
+	matching := state.matchingSequences(keys)
+	if len(matching) == 0 {
+		if ev.Code == evdev.KEY_ESC && len(p.keys) > 0 {
+			fmt.Printf("  Sequence %s: canceled by esc\n", SliceOfKeysToString(p.keys))
+			state.sequence = nil
+			state.swallowUnreleasedSequenceKeys(p, ev.Code)
+			return true, nil
+		}
+		return false, state.cancelSequence("no match")
+	}
+	p.keys = keys
+	p.events = append(p.events, ev)
+	p.timeout = matching[0].Timeout
+	for _, seq := range matching {
+		if len(seq.Keys) != len(keys) {
+			continue
+		}
+		fmt.Printf("  Sequence %s: finished\n", seq.String())
+		state.sequence = nil
+		state.swallowUnreleasedSequenceKeys(p)
+		if err := state.WriteCombo(&seq.Combo, ev.Time, DOWN); err != nil {
+			return true, err
+		}
+		return true, state.WriteCombo(&seq.Combo, ev.Time, UP)
+	}
+	fmt.Printf("  Sequence %s: waiting for next key\n", SliceOfKeysToString(p.keys))
+	state.startTimer(ev.Time, p.timeout)
+	return true, nil
+}
+
+// handleLeaderTap starts a sequence, if a leader which is part of a combo got tapped: the
+// buffer contains only the down-event of the leader. If another key was pressed in between, or if
+// the leader belongs to a combo which was written, the combo engine decides.
+func (state *State) handleLeaderTap(ev Event) bool {
+	if len(state.buf) != 1 || state.buf[0].Code != ev.Code || state.buf[0].Value != DOWN ||
+		len(state.downKeysWritten) > 0 || slices.Contains(state.swallowKeys, ev.Code) ||
+		!state.startsSequence(ev.Code) {
+		return false
+	}
+	keys := []KeyCode{ev.Code}
+	p := &pendingSequence{
+		keys:    keys,
+		events:  []Event{state.buf[0], ev},
+		timeout: state.matchingSequences(keys)[0].Timeout,
+	}
+	state.buf = state.buf[:0]
+	state.sequence = p
+	fmt.Printf("  Sequence %s: leader tapped, waiting for next key\n", SliceOfKeysToString(p.keys))
+	state.startTimer(ev.Time, p.timeout)
+	return true
+}
+
+// swallowUnreleasedSequenceKeys: the up-events of keys which are still down must not be written.
+func (state *State) swallowUnreleasedSequenceKeys(p *pendingSequence, extraKeys ...KeyCode) {
+	down := make(map[KeyCode]int, len(p.keys))
+	for _, ev := range p.events {
+		switch ev.Value {
+		case DOWN:
+			down[ev.Code]++
+		case UP:
+			down[ev.Code]--
+		}
+	}
+	for key, count := range down {
+		if count > 0 {
+			state.sequenceSwallowUps = append(state.sequenceSwallowUps, key)
+		}
+	}
+	state.sequenceSwallowUps = append(state.sequenceSwallowUps, extraKeys...)
+}
+
+// evalSequence gets called by Eval while a sequence is in progress.
+func (state *State) evalSequence(currTime time.Time) error {
+	p := state.sequence
+	var lastDownTime time.Time
+	for _, ev := range p.events {
+		if ev.Value == DOWN {
+			lastDownTime = syscallTimevalToTime(ev.Time)
+		}
+	}
+	if currTime.Sub(lastDownTime) < p.timeout {
+		return nil
+	}
+	return state.cancelSequence("timeout")
+}
+
+// cancelSequence writes the swallowed events. This way the user sees that the sequence was
+// not finished.
+func (state *State) cancelSequence(reason string) error {
+	p := state.sequence
+	state.sequence = nil
+	if p == nil {
+		return nil
+	}
+	fmt.Printf("  Sequence %s: canceled (%s)\n", SliceOfKeysToString(p.keys), reason)
+	for _, ev := range p.events {
+		if err := state.WriteEvent(ev, "Sequence>canceled"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package tff
+
+import (
+	"testing"
+)
+
+var sequenceYaml = `
+sequences:
+  - keys: capslock g h
+    outKeys: home
+  - keys: capslock g g
+    outKeys: end
+    timeout: 500ms
+`
+
+func Test_Sequence_Match(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (200ms) g_ (50ms) g/ (200ms) h_ (50ms) h/ (200ms) x_ (50ms) x/
+	`,
+		`
+		HOME-down
+		HOME-up
+		X-down
+		X-up
+	`,
+		sequenceYaml)
+}
+
+func Test_Sequence_SameKeyTwice(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (200ms) g_ (50ms) g/ (200ms) g_ (50ms) g/
+	`,
+		`
+		END-down
+		END-up
+	`,
+		sequenceYaml)
+}
+
+func Test_Sequence_Canceled_NoMatch(t *testing.T) {
+	// The swallowed keys get written, so that the user sees that the sequence was canceled.
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (200ms) g_ (50ms) g/ (200ms) x_ (50ms) x/
+	`,
+		`
+		CAPSLOCK-down
+		CAPSLOCK-up
+		G-down
+		G-up
+		X-down
+		X-up
+	`,
+		sequenceYaml)
+}
+
+func Test_Sequence_Canceled_Timeout(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (2s) g_ (50ms) g/ (200ms) h_ (50ms) h/
+	`,
+		`
+		CAPSLOCK-down
+		CAPSLOCK-up
+		G-down
+		G-up
+		H-down
+		H-up
+	`,
+		sequenceYaml)
+}
+
+func Test_Sequence_Canceled_Esc(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (200ms) esc_ (50ms) esc/ (200ms) x_ (50ms) x/
+	`,
+		`
+		X-down
+		X-up
+	`,
+		sequenceYaml)
+}
+
+var sequenceAndComboYaml = `
+combos:
+  - keys: capslock n
+    outKeys: down
+sequences:
+  - keys: capslock g h
+    outKeys: home
+`
+
+func Test_Sequence_LeaderInCombo_Combo(t *testing.T) {
+	// The leader is part of a combo. Holding it does not start the sequence.
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (20ms) n_ (300ms) n/ (20ms) capslock/
+	`,
+		`
+		DOWN-down
+		DOWN-up
+	`,
+		sequenceAndComboYaml)
+}
+
+func Test_Sequence_LeaderInCombo_Tap(t *testing.T) {
+	// Tapping the leader starts the sequence.
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (200ms) g_ (50ms) g/ (200ms) h_ (50ms) h/ (200ms) x_ (50ms) x/
+	`,
+		`
+		HOME-down
+		HOME-up
+		X-down
+		X-up
+	`,
+		sequenceAndComboYaml)
+}
@@ -0,0 +1,313 @@
+package tff
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// DefaultStatsFile is the default path of 'tff stats'.
+const DefaultStatsFile = "/var/lib/tff/stats.json"
+
+// StatsSaveInterval: the stats get saved once per interval, if they changed, and when tff stops.
+var StatsSaveInterval = time.Minute
+
+// hardToReachKeys get counted, if they are pressed directly. Maybe a combo would be better.
+var hardToReachKeys = []KeyCode{
+	evdev.KEY_UP, evdev.KEY_DOWN, evdev.KEY_LEFT, evdev.KEY_RIGHT,
+	evdev.KEY_HOME, evdev.KEY_END, evdev.KEY_PAGEUP, evdev.KEY_PAGEDOWN,
+	evdev.KEY_BACKSPACE, evdev.KEY_DELETE, evdev.KEY_ESC,
+}
+
+// Near misses: the keys of a combo were pressed, but the combo did not match.
+const (
+	nearMissOverlap = "Overlap too short"
+	nearMissOrder   = "Order is wrong"
+)
+
+// Stats contains the usage counts. It gets saved as JSON to 'statsFile' of combos.yaml.
+type Stats struct {
+	Since time.Time `json:"since"`
+
+	// Combos: how often each combo was used.
+	Combos map[string]int `json:"combos"`
+
+	// NearMisses: combo to reason to count.
+	NearMisses map[string]map[string]int `json:"nearMisses"`
+
+	// DirectKeys: how often each hard-to-reach key was pressed directly.
+	DirectKeys map[string]int `json:"directKeys"`
+
+	// Blocked: how often each blocked key was pressed.
+	Blocked map[string]int `json:"blocked"`
+
+	// Training: day to key to count. Keys which were pressed directly, although a combo
+	// produces them.
+	Training map[string]map[string]int `json:"training"`
+}
+
+func newStats(since time.Time) *Stats {
+	return &Stats{
+		Since:      since,
+		Combos:     make(map[string]int),
+		NearMisses: make(map[string]map[string]int),
+		DirectKeys: make(map[string]int),
+		Blocked:    make(map[string]int),
+		Training:   make(map[string]map[string]int),
+	}
+}
+
+// statsRegistry contains the stats of all engines of the process. Each device has its own engine,
+// but they share one file.
+var statsRegistry = struct {
+	sync.Mutex
+	path  string
+	stats *Stats
+	dirty bool
+
+	// broken: the file could not be read. It does not get overwritten, so the counts of the
+	// file do not get lost.
+	broken bool
+
+	saver sync.Once
+}{}
+
+// updateStats changes the stats of the file. They get saved by saveStatsPeriodically.
+func updateStats(path string, now time.Time, update func(stats *Stats)) {
+	statsRegistry.Lock()
+	defer statsRegistry.Unlock()
+	if statsRegistry.path != path {
+		saveStatsLocked()
+		stats, err := LoadStats(path)
+		statsRegistry.broken = err != nil
+		if err != nil {
+			fmt.Printf("%s. The stats do not get saved\n", err.Error())
+		}
+		if stats == nil {
+			stats = newStats(now)
+		}
+		statsRegistry.path = path
+		statsRegistry.stats = stats
+		statsRegistry.saver.Do(func() {
+			go saveStatsPeriodically(StatsSaveInterval)
+		})
+	}
+	update(statsRegistry.stats)
+	statsRegistry.dirty = true
+}
+
+// saveStatsPeriodically saves the stats once per interval, so the event loop does not wait
+// for the disk.
+func saveStatsPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		flushStats()
+	}
+}
+
+// flushStats saves the stats, if they were changed since the last save.
+func flushStats() {
+	statsRegistry.Lock()
+	defer statsRegistry.Unlock()
+	saveStatsLocked()
+}
+
+func saveStatsLocked() {
+	if !statsRegistry.dirty || statsRegistry.broken {
+		return
+	}
+	if err := saveStats(statsRegistry.path, statsRegistry.stats); err != nil {
+		fmt.Printf("failed to save stats: %s\n", err.Error())
+		return
+	}
+	statsRegistry.dirty = false
+}
+
+// saveStats writes a temporary file and renames it. This way the file is never half written,
+// even if tff gets killed while saving.
+func saveStats(path string, stats *Stats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	err = errors.Join(err, tmp.Chmod(0o644), tmp.Close())
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadStats reads the stats file. A missing file returns nil stats without error.
+func LoadStats(path string) (*Stats, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats: %w", err)
+	}
+	stats := newStats(time.Time{})
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, fmt.Errorf("failed to parse stats %q: %w", path, err)
+	}
+	// "null" in the file removes the map.
+	empty := newStats(stats.Since)
+	if stats.Combos == nil {
+		stats.Combos = empty.Combos
+	}
+	if stats.NearMisses == nil {
+		stats.NearMisses = empty.NearMisses
+	}
+	if stats.DirectKeys == nil {
+		stats.DirectKeys = empty.DirectKeys
+	}
+	if stats.Blocked == nil {
+		stats.Blocked = empty.Blocked
+	}
+	if stats.Training == nil {
+		stats.Training = empty.Training
+	}
+	return stats, nil
+}
+
+func (state *State) updateStats(now time.Time, update func(stats *Stats)) {
+	if state.config.StatsFile == "" {
+		return
+	}
+	updateStats(state.config.StatsFile, now, update)
+}
+
+func (state *State) countCombo(combo *Combo, now time.Time) {
+	state.updateStats(now, func(stats *Stats) {
+		stats.Combos[combo.String()]++
+	})
+}
+
+// nearMissOf returns the reason, if the message of EvalCombo is a near miss.
+func nearMissOf(msg string) string {
+	for _, reason := range []string{nearMissOverlap, nearMissOrder} {
+		if strings.HasPrefix(msg, reason) {
+			return reason
+		}
+	}
+	return ""
+}
+
+type nearMiss struct {
+	combo  *Combo
+	reason string
+}
+
+func (state *State) countNearMisses(nearMisses []nearMiss, now time.Time) {
+	if len(nearMisses) == 0 {
+		return
+	}
+	state.updateStats(now, func(stats *Stats) {
+		for _, miss := range nearMisses {
+			name := miss.combo.String()
+			if stats.NearMisses[name] == nil {
+				stats.NearMisses[name] = make(map[string]int)
+			}
+			stats.NearMisses[name][miss.reason]++
+		}
+	})
+}
+
+// countKeyPress counts the down-events of hard-to-reach keys and of blocked keys.
+func (state *State) countKeyPress(ev *Event, blocked bool) {
+	if ev.Type != evdev.EV_KEY || ev.Value != DOWN {
+		return
+	}
+	if !blocked && !slices.Contains(hardToReachKeys, ev.Code) {
+		return
+	}
+	state.updateStats(syscallTimevalToTime(ev.Time), func(stats *Stats) {
+		if blocked {
+			stats.Blocked[keyToString(ev.Code)]++
+			return
+		}
+		stats.DirectKeys[keyToString(ev.Code)]++
+	})
+}
+
+// Report returns the stats as text. The biggest counts come first.
+func (s *Stats) Report() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Since %s\n", s.Since.Format(time.DateTime))
+	writeCounts(&sb, "Combos", s.Combos)
+	misses := make(map[string]int)
+	for combo, reasons := range s.NearMisses {
+		for reason, n := range reasons {
+			misses[fmt.Sprintf("%s (%s)", combo, strings.ToLower(reason))] = n
+		}
+	}
+	writeCounts(&sb, "Near misses", misses)
+	writeCounts(&sb, "Hard-to-reach keys pressed directly", s.DirectKeys)
+	writeCounts(&sb, "Blocked keys", s.Blocked)
+	writeTraining(&sb, s.Training)
+	return sb.String()
+}
+
+// writeTraining writes one line per day. Less keys which a combo produces is progress.
+func writeTraining(sb *strings.Builder, training map[string]map[string]int) {
+	if len(training) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "\nTraining, keys pressed directly although a combo produces them:\n")
+	for _, day := range slices.Sorted(maps.Keys(training)) {
+		counts := training[day]
+		keys := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+			return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+		})
+		total := 0
+		s := make([]string, 0, len(keys))
+		for _, key := range keys {
+			total += counts[key]
+			s = append(s, fmt.Sprintf("%s %d", key, counts[key]))
+		}
+		fmt.Fprintf(sb, "%s %8d  %s\n", day, total, strings.Join(s, ", "))
+	}
+}
+
+func writeCounts(sb *strings.Builder, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "\n%s:\n", title)
+	names := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	for _, name := range names {
+		fmt.Fprintf(sb, "%8d  %s\n", counts[name], name)
+	}
+}
+
+// StatsMain prints the stats of the file.
+func StatsMain(path string) error {
+	stats, err := LoadStats(path)
+	if err != nil {
+		return err
+	}
+	if stats == nil {
+		return fmt.Errorf("%q does not exist. Set 'statsFile' in combos.yaml to collect stats", path)
+	}
+	fmt.Print(stats.Report())
+	return nil
+}
@@ -0,0 +1,94 @@
+package tff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Stats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	config, err := LoadYamlFromBytes([]byte(`
+statsFile: ` + path + `
+block:
+  - capslock
+combos:
+  - keys: f j
+    outKeys: x
+`))
+	require.NoError(t, err)
+	input := "f_ (20ms) j_ (200ms) j/ (20ms) f/ (200ms) " +
+		// overlap of f and j is too short.
+		"f_ (20ms) j_ (10ms) f/ (200ms) j/ (200ms) " +
+		"backspace_ (20ms) backspace/ (200ms) capslock_ (20ms) capslock/"
+
+	// The counts get added up over several sessions.
+	for range 2 {
+		er, err := NewReadFromSliceInputStateString(input)
+		require.NoError(t, err)
+		require.NoError(t, manInTheMiddle(context.Background(), er, &writeToSlice{}, config, VirtualClock{}, nil))
+	}
+
+	stats, err := LoadStats(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"KEY_F KEY_J -> KEY_X": 2}, stats.Combos)
+	require.Equal(t, map[string]map[string]int{"KEY_F KEY_J -> KEY_X": {nearMissOverlap: 2}}, stats.NearMisses)
+	require.Equal(t, map[string]int{"BACKSPACE": 2}, stats.DirectKeys)
+	require.Equal(t, map[string]int{"CAPSLOCK": 2}, stats.Blocked)
+
+	require.Contains(t, stats.Report(), `
+Combos:
+       2  KEY_F KEY_J -> KEY_X
+
+Near misses:
+       2  KEY_F KEY_J -> KEY_X (overlap too short)
+
+Hard-to-reach keys pressed directly:
+       2  BACKSPACE
+
+Blocked keys:
+       2  CAPSLOCK
+`)
+}
+
+func Test_StatsMain_MissingFile(t *testing.T) {
+	err := StatsMain(filepath.Join(t.TempDir(), "stats.json"))
+	require.ErrorContains(t, err, "does not exist. Set 'statsFile' in combos.yaml")
+}
+
+func Test_Stats_BrokenFileDoesNotGetOverwritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"combos": {`), 0o644))
+	config, err := LoadYamlFromBytes([]byte(`
+statsFile: ` + path + `
+combos:
+  - keys: f j
+    outKeys: x
+`))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString("f_ (20ms) j_ (200ms) j/ (20ms) f/")
+	require.NoError(t, err)
+	require.NoError(t, manInTheMiddle(context.Background(), er, &writeToSlice{}, config, VirtualClock{}, nil))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{"combos": {`, string(data))
+}
+
+func Test_saveStats(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats.json")
+	require.NoError(t, saveStats(path, newStats(time.Time{})))
+	require.NoError(t, saveStats(path, newStats(time.Time{})))
+
+	stats, err := LoadStats(path)
+	require.NoError(t, err)
+	require.Empty(t, stats.Combos)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "the temporary file must be removed")
+}
@@ -0,0 +1,174 @@
+package tff
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultTappingTerm is used if a tap-hold key has no tappingTerm.
+const DefaultTappingTerm = 200 * time.Millisecond
+
+// TapHold is a key which emits Tap if it gets tapped, and acts like HoldKeys (for example a
+// modifier) or activates HoldLayer while it is held.
+type TapHold struct {
+	Key KeyCode
+	Tap KeyCode
+
+	// Exactly one of HoldKeys and HoldLayer is set.
+	HoldKeys  []KeyCode
+	HoldLayer string
+
+	// If the key is held longer than TappingTerm, then it is a hold.
+	TappingTerm time.Duration
+
+	// HoldOnOtherKeyPress: pressing an other key while the tap-hold key is held is a hold,
+	// even if the TappingTerm is not reached yet.
+	HoldOnOtherKeyPress bool
+
+	// PermissiveHold: tapping an other key (down and up) while the tap-hold key is held is a
+	// hold, even if the TappingTerm is not reached yet.
+	PermissiveHold bool
+}
+
+func (th *TapHold) String() string {
+	hold := th.HoldLayer
+	if hold == "" {
+		hold = SliceOfKeysToString(th.HoldKeys)
+	}
+	return fmt.Sprintf("%s (tap %s, hold %s)", keyToString(th.Key), keyToString(th.Tap), hold)
+}
+
+// pendingTapHold is a tap-hold key which is down, but it is not decided yet whether it is
+// a tap or a hold.
+type pendingTapHold struct {
+	tapHold *TapHold
+	down    Event
+
+	// Events which arrived while the decision was pending. They get handled after the
+	// decision was made.
+	queue []Event
+}
+
+func (p *pendingTapHold) elapsed(currTime time.Time) time.Duration {
+	return currTime.Sub(syscallTimevalToTime(p.down.Time))
+}
+
+func (state *State) handleTapHoldKey(th *TapHold, ev Event) error {
+	switch ev.Value {
+	case DOWN:
+		// The buffer gets evaluated before the tap-hold key, like for any other key
+		// which is not part of a combo.
+		if err := state.FlushBuffer("TapHold"); err != nil {
+			return err
+		}
+		state.tapHold = &pendingTapHold{
+			tapHold: th,
+			down:    ev,
+		}
+		state.startTimer(ev.Time, th.TappingTerm)
+		return nil
+	case UP:
+		p := state.tapHold
+		if p != nil && p.down.Code == ev.Code {
+			hold := p.elapsed(syscallTimevalToTime(ev.Time)) >= th.TappingTerm
+			if err := state.decideTapHold(hold, "released"); err != nil {
+				return err
+			}
+			if !hold {
+				return nil
+			}
+		}
+		return state.releaseTapHold(th, ev)
+	default:
+		return fmt.Errorf("Received %d. Expected UP or DOWN", ev.Value)
+	}
+}
+
+// queueForTapHold gets called for all events while a tap-hold decision is pending.
+func (state *State) queueForTapHold(ev Event) error {
+	p := state.tapHold
+	th := p.tapHold
+	if p.elapsed(syscallTimevalToTime(ev.Time)) >= th.TappingTerm {
+		// The timer did not fire yet. Handle the decision first.
+		if err := state.decideTapHold(true, "tapping term reached"); err != nil {
+			return err
+		}
+		return state.HandleKey(ev)
+	}
+	p.queue = append(p.queue, ev)
+	if ev.Value == DOWN && th.HoldOnOtherKeyPress {
+		return state.decideTapHold(true, "hold on other key press")
+	}
+	if ev.Value == UP && th.PermissiveHold {
+		for _, queued := range p.queue {
+			if queued.Code == ev.Code && queued.Value == DOWN {
+				return state.decideTapHold(true, "permissive hold")
+			}
+		}
+	}
+	return nil
+}
+
+// evalTapHold gets called by Eval while a tap-hold decision is pending.
+func (state *State) evalTapHold(currTime time.Time) error {
+	if state.tapHold.elapsed(currTime) < state.tapHold.tapHold.TappingTerm {
+		return nil
+	}
+	return state.decideTapHold(true, "tapping term reached")
+}
+
+func (state *State) decideTapHold(hold bool, reason string) error {
+	p := state.tapHold
+	th := p.tapHold
+	state.tapHold = nil
+	if hold {
+		fmt.Printf("  TapHold %s: hold (%s)\n", th.String(), reason)
+		if th.HoldLayer != "" {
+			if err := state.applyLayerAction(&LayerAction{Type: LayerHold, Layer: th.HoldLayer}, p.down); err != nil {
+				return err
+			}
+		}
+		for _, key := range th.HoldKeys {
+			ev := p.down
+			ev.Code = key
+			if err := state.WriteEvent(ev, "TapHold>hold"); err != nil {
+				return err
+			}
+		}
+	} else {
+		fmt.Printf("  TapHold %s: tap (%s)\n", th.String(), reason)
+		ev := p.down
+		ev.Code = th.Tap
+		if err := state.WriteEvent(ev, "TapHold>tap"); err != nil {
+			return err
+		}
+		ev.Value = UP
+		if err := state.WriteEvent(ev, "TapHold>tap"); err != nil {
+			return err
+		}
+	}
+	for _, ev := range p.queue {
+		if err := state.HandleKey(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (state *State) releaseTapHold(th *TapHold, ev Event) error {
+	if th.HoldLayer != "" {
+		return state.applyLayerAction(&LayerAction{Type: LayerHold, Layer: th.HoldLayer}, ev)
+	}
+	// Keys in the buffer were pressed while the hold-keys were down.
+	if err := state.FlushBuffer("TapHold>release"); err != nil {
+		return err
+	}
+	for i := len(th.HoldKeys) - 1; i >= 0; i-- {
+		upEv := ev
+		upEv.Code = th.HoldKeys[i]
+		if err := state.WriteEvent(upEv, "TapHold>release"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
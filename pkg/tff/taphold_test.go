@@ -0,0 +1,139 @@
+package tff
+
+import (
+	"testing"
+)
+
+var homeRowYaml = `
+tapHold:
+  - key: f
+    hold: leftctrl
+  - key: d
+    hold: leftshift
+    holdOnOtherKeyPress: true
+  - key: s
+    hold: leftalt
+    permissiveHold: true
+  - key: a
+    holdLayer: nav
+    tappingTerm: 150ms
+layers:
+  - name: nav
+    remaps:
+      - key: j
+        outKey: left
+`
+
+func Test_TapHold_Tap(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (50ms) f/ (100ms) x_ (50ms) x/
+	`,
+		`
+		F-down
+		F-up
+		X-down
+		X-up
+	`,
+		homeRowYaml)
+}
+
+func Test_TapHold_HoldAfterTappingTerm(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (300ms) c_ (50ms) c/ (50ms) f/
+	`,
+		`
+		LEFTCTRL-down
+		C-down
+		C-up
+		LEFTCTRL-up
+	`,
+		homeRowYaml)
+}
+
+func Test_TapHold_HoldWithoutOtherKey(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (300ms) f/
+	`,
+		`
+		LEFTCTRL-down
+		LEFTCTRL-up
+	`,
+		homeRowYaml)
+}
+
+func Test_TapHold_FastRollIsTap(t *testing.T) {
+	// Without holdOnOtherKeyPress and permissiveHold, only the tapping term decides.
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (50ms) c_ (50ms) c/ (50ms) f/
+	`,
+		`
+		F-down
+		F-up
+		C-down
+		C-up
+	`,
+		homeRowYaml)
+}
+
+func Test_TapHold_HoldOnOtherKeyPress(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		d_ (50ms) c_ (50ms) c/ (50ms) d/
+	`,
+		`
+		LEFTSHIFT-down
+		C-down
+		C-up
+		LEFTSHIFT-up
+	`,
+		homeRowYaml)
+}
+
+func Test_TapHold_PermissiveHold(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		s_ (50ms) c_ (50ms) c/ (50ms) s/
+	`,
+		`
+		LEFTALT-down
+		C-down
+		C-up
+		LEFTALT-up
+	`,
+		homeRowYaml)
+}
+
+func Test_TapHold_PermissiveHold_Roll(t *testing.T) {
+	// "s" gets released before "c". This is a roll, not a hold.
+	AssertYamlStateStringInputOutput(t,
+		`
+		s_ (50ms) c_ (50ms) s/ (50ms) c/
+	`,
+		`
+		S-down
+		S-up
+		C-down
+		C-up
+	`,
+		homeRowYaml)
+}
+
+func Test_TapHold_HoldLayer(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		a_ (200ms) j_ (50ms) j/ (50ms) a/ (100ms) a_ (50ms) a/ (100ms) j_ (50ms) j/
+	`,
+		`
+		LEFT-down
+		LEFT-up
+		A-down
+		A-up
+		J-down
+		J-up
+	`,
+		homeRowYaml)
+}
@@ -0,0 +1,132 @@
+package tff
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// Values of 'unicode' in 'text'. They define how characters get typed, which are not on the
+// keyboard layout.
+const (
+	// UnicodeCtrlShiftU types ctrl+shift+u, the code point in hex and space. This is supported
+	// by GTK and IBus.
+	UnicodeCtrlShiftU = "ctrlShiftU"
+
+	// UnicodeNone: characters which are not on the keyboard layout are an error.
+	UnicodeNone = "none"
+)
+
+type yamlText struct {
+	Unicode string            `yaml:"unicode"`
+	Compose map[string]string `yaml:"compose"`
+}
+
+// textTyper converts the strings of 'outText' to output steps.
+type textTyper struct {
+	layout  *keyboardLayout
+	unicode string
+
+	// compose contains the steps of 'text: compose'. They are used before the layout.
+	compose map[rune][]OutputStep
+}
+
+func yamlTextToTextTyper(y yamlText, layout *keyboardLayout) (*textTyper, error) {
+	if layout == nil {
+		layout = layoutUS
+	}
+	t := textTyper{
+		layout:  layout,
+		unicode: y.Unicode,
+		compose: make(map[rune][]OutputStep, len(y.Compose)),
+	}
+	switch t.unicode {
+	case "":
+		t.unicode = UnicodeCtrlShiftU
+	case UnicodeCtrlShiftU, UnicodeNone:
+	default:
+		return nil, fmt.Errorf("invalid 'unicode: %s' in 'text'. Valid values: %s, %s",
+			y.Unicode, UnicodeCtrlShiftU, UnicodeNone)
+	}
+	for char, outKeys := range y.Compose {
+		r, size := utf8.DecodeRuneInString(char)
+		if r == utf8.RuneError || size != len(char) {
+			return nil, fmt.Errorf("'text: compose': %q is not a single character.", char)
+		}
+		keys, output, err := stringToOutput(outKeys)
+		if err != nil {
+			return nil, fmt.Errorf("'text: compose': %q: %w", char, err)
+		}
+		if output == nil {
+			output = []OutputStep{{Keys: keys}}
+		}
+		t.compose[r] = output
+	}
+	return &t, nil
+}
+
+// textToOutput returns the steps which type the text.
+func (t *textTyper) textToOutput(text string) ([]OutputStep, error) {
+	var steps []OutputStep
+	for _, r := range text {
+		runeSteps, err := t.runeToOutput(r)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, runeSteps...)
+	}
+	return steps, nil
+}
+
+func (t *textTyper) runeToOutput(r rune) ([]OutputStep, error) {
+	if steps, ok := t.compose[r]; ok {
+		return steps, nil
+	}
+	if k, ok := t.layout.typeable(r); ok {
+		return []OutputStep{k.step()}, nil
+	}
+	if t.unicode == UnicodeNone {
+		return nil, fmt.Errorf("character %q is not on the keyboard layout, and not in 'text: compose'.", r)
+	}
+	// ctrl+shift+u, the code point in hex, space.
+	steps := []OutputStep{{Keys: []KeyCode{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTSHIFT, evdev.KEY_U}}}
+	for _, digit := range strconv.FormatInt(int64(r), 16) {
+		k, ok := t.layout.typeable(digit)
+		if !ok {
+			return nil, fmt.Errorf("hex digit %q is not on the layout %q.", digit, t.layout.name)
+		}
+		steps = append(steps, k.step())
+	}
+	return append(steps, OutputStep{Keys: []KeyCode{evdev.KEY_SPACE}}), nil
+}
+
+// applyText sets the output steps of all combos and sequences with 'outText'.
+func applyText(config *Config, y yamlText) error {
+	t, err := yamlTextToTextTyper(y, config.Layout)
+	if err != nil {
+		return err
+	}
+	for _, layer := range config.Layers {
+		for _, combo := range layer.Combos {
+			if combo.Text == "" {
+				continue
+			}
+			combo.Output, err = t.textToOutput(combo.Text)
+			if err != nil {
+				return fmt.Errorf("combo %q: 'outText': %w", combo.keysString(), err)
+			}
+		}
+		for _, seq := range layer.Sequences {
+			if seq.Text == "" {
+				continue
+			}
+			seq.Output, err = t.textToOutput(seq.Text)
+			if err != nil {
+				return fmt.Errorf("sequence %q: 'outText': %w", seq.keysString(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package tff
+
+import (
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_textToOutput(t *testing.T) {
+	typer, err := yamlTextToTextTyper(yamlText{
+		Compose: map[string]string{"ä": "compose, shift+apostrophe, a"},
+	}, nil)
+	require.NoError(t, err)
+
+	steps, err := typer.textToOutput("a:")
+	require.NoError(t, err)
+	require.Equal(t, []OutputStep{
+		{Keys: []KeyCode{evdev.KEY_A}},
+		{Keys: []KeyCode{evdev.KEY_LEFTSHIFT, evdev.KEY_SEMICOLON}},
+	}, steps)
+
+	steps, err = typer.textToOutput("ä")
+	require.NoError(t, err)
+	require.Equal(t, []OutputStep{
+		{Keys: []KeyCode{evdev.KEY_COMPOSE}},
+		{Keys: []KeyCode{evdev.KEY_LEFTSHIFT, evdev.KEY_APOSTROPHE}},
+		{Keys: []KeyCode{evdev.KEY_A}},
+	}, steps)
+
+	steps, err = typer.textToOutput("→")
+	require.NoError(t, err)
+	require.Equal(t, []OutputStep{
+		{Keys: []KeyCode{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTSHIFT, evdev.KEY_U}},
+		{Keys: []KeyCode{evdev.KEY_2}},
+		{Keys: []KeyCode{evdev.KEY_1}},
+		{Keys: []KeyCode{evdev.KEY_9}},
+		{Keys: []KeyCode{evdev.KEY_2}},
+		{Keys: []KeyCode{evdev.KEY_SPACE}},
+	}, steps)
+
+	typer, err = yamlTextToTextTyper(yamlText{Unicode: UnicodeNone}, nil)
+	require.NoError(t, err)
+	_, err = typer.textToOutput("→")
+	require.ErrorContains(t, err, `character '→' is not on the keyboard layout`)
+
+	_, err = yamlTextToTextTyper(yamlText{Unicode: "xkb"}, nil)
+	require.ErrorContains(t, err, `invalid 'unicode: xkb'`)
+
+	_, err = yamlTextToTextTyper(yamlText{Compose: map[string]string{"ae": "a, e"}}, nil)
+	require.ErrorContains(t, err, `"ae" is not a single character.`)
+}
+
+var textYaml = `
+combos:
+  - keys: f j
+    outText: Hi!
+sequences:
+  - keys: capslock a
+    outText: →
+`
+
+func Test_OutText(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		f_ (50ms) j_ (150ms) j/ (50ms) f/
+	`,
+		`
+		LEFTSHIFT-down
+		H-down
+		H-up
+		LEFTSHIFT-up
+		I-down
+		I-up
+		LEFTSHIFT-down
+		1-down
+		1-up
+		LEFTSHIFT-up
+	`,
+		textYaml)
+}
+
+func Test_OutText_Sequence(t *testing.T) {
+	AssertYamlStateStringInputOutput(t,
+		`
+		capslock_ (50ms) capslock/ (200ms) a_ (50ms) a/
+	`,
+		`
+		LEFTCTRL-down
+		LEFTSHIFT-down
+		U-down
+		U-up
+		LEFTSHIFT-up
+		LEFTCTRL-up
+		2-down
+		2-up
+		1-down
+		1-up
+		9-down
+		9-up
+		2-down
+		2-up
+		SPACE-down
+		SPACE-up
+	`,
+		textYaml)
+}
+
+func Test_OutText_LoadYaml(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(textYaml))
+	require.NoError(t, err)
+	require.Equal(t, `KEY_F KEY_J -> text "Hi!"`, config.BaseLayer().Combos[0].String())
+
+	_, err = LoadYamlFromBytes([]byte(`
+combos:
+  - keys: f j
+    outKeys: x
+    outText: x
+`))
+	require.ErrorContains(t, err, `combo "f j": 'outKeys' and 'outText' are mutually exclusive.`)
+
+	_, err = LoadYamlFromBytes([]byte(`
+text:
+  unicode: none
+combos:
+  - keys: f j
+    outText: ä
+`))
+	require.ErrorContains(t, err, `combo "KEY_F KEY_J": 'outText': character 'ä' is not on the keyboard layout`)
+}
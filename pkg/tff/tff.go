@@ -330,7 +330,11 @@ func csvlineToEvent(line string) (Event, error) {
 	case "MSC_SCAN":
 		code = evdev.MSC_SCAN
 	default:
-		code, ok = evdev.KEYFromString[parts[3]]
+		codes := evdev.KEYFromString
+		if evType == evdev.EV_REL {
+			codes = evdev.RELFromString
+		}
+		code, ok = codes[parts[3]]
 		if !ok {
 			return ev, fmt.Errorf("failed to parse col 4 (Key) from line: %s. %q", line, parts[3])
 		}
@@ -360,6 +364,48 @@ func csvlineToEvent(line string) (Event, error) {
 type Combo struct {
 	Keys    []KeyCode
 	OutKeys []KeyCode
+
+	// KeyGroups defines the order of the Keys. Keys in the same group can be pressed in any
+	// order. nil means strict order: the keys must be pressed in the order of Keys.
+	KeyGroups [][]KeyCode
+
+	// Timing overrides the global timing. Zero values mean "use the global value".
+	Timing Timing
+
+	// Output contains the steps of outKeys, if outKeys contains more than one chord or a delay.
+	// nil means: all OutKeys get pressed together.
+	Output []OutputStep
+
+	// Text is the string of 'outText'. Output contains the steps which type it. The whole text
+	// gets typed, when the combo gets pressed.
+	Text string
+
+	// Pacing overrides the global OutputPacing. Zero means "use the global value".
+	Pacing time.Duration
+
+	// Modifiers must be held, so that the combo matches. Each entry contains the keys which
+	// satisfy one modifier (for example left and right shift).
+	Modifiers [][]KeyCode
+
+	// Run executes a command. nil means: no command.
+	Run *RunAction
+
+	// Mouse is a mouse action, which is active while the combo is held. nil means: no mouse
+	// action.
+	Mouse *MouseAction
+
+	// Macro records or plays a macro. nil means: no macro action.
+	Macro *MacroAction
+
+	// KeyDevices restricts keys to a device. Keys which are not in the map can come from any
+	// device. Only used, if 'devices' are configured.
+	KeyDevices map[KeyCode]string
+
+	// Group is the name of a group of combos. A group can be disabled via the control socket.
+	Group string
+
+	// Line in combos.yaml. Zero, if the combo was not loaded from yaml.
+	Line int
 }
 
 func (c *Combo) matches(ev Event) bool {
@@ -367,19 +413,41 @@ func (c *Combo) matches(ev Event) bool {
 }
 
 func (c *Combo) String() string {
-	keys := make([]string, 0, len(c.Keys))
-	for _, k := range c.Keys {
-		keys = append(keys, evdev.CodeName(evdev.EV_KEY, k))
+	if len(c.Modifiers) > 0 {
+		return modifiersString(c.Modifiers) + "+" + c.stringWithoutModifiers()
+	}
+	return c.stringWithoutModifiers()
+}
+
+func (c *Combo) stringWithoutModifiers() string {
+	if c.Text != "" {
+		return fmt.Sprintf("%+v -> text %q", c.keysString(), c.Text)
+	}
+	if c.Macro != nil && len(c.OutKeys) == 0 {
+		return fmt.Sprintf("%+v -> macro %s", c.keysString(), c.Macro.String())
+	}
+	if c.Mouse != nil && len(c.OutKeys) == 0 {
+		return fmt.Sprintf("%+v -> mouse %s", c.keysString(), c.Mouse.String())
+	}
+	if c.Run != nil && len(c.OutKeys) == 0 {
+		return fmt.Sprintf("%+v -> %s", c.keysString(), c.Run.String())
+	}
+	if c.Output != nil {
+		steps := make([]string, 0, len(c.Output))
+		for _, step := range c.Output {
+			steps = append(steps, step.String())
+		}
+		return fmt.Sprintf("%+v -> %+v", c.keysString(), strings.Join(steps, ", "))
 	}
 	out := make([]string, 0, len(c.OutKeys))
 	for _, k := range c.OutKeys {
-		out = append(out, evdev.CodeName(evdev.EV_KEY, k))
+		out = append(out, keyCodeName(k))
 	}
-	return fmt.Sprintf("%+v -> %+v", strings.Join(keys, " "), strings.Join(out, " "))
+	return fmt.Sprintf("%+v -> %+v", c.keysString(), strings.Join(out, " "))
 }
 
 func keyToString(key KeyCode) string {
-	return strings.TrimPrefix(evdev.KEYToString[key], "KEY_")
+	return strings.TrimPrefix(keyCodeName(key), "KEY_")
 }
 
 func SliceOfKeysToString(keys []KeyCode) string {
@@ -397,40 +465,61 @@ type EventWriter interface {
 	WriteOne(event *Event) error
 }
 
-func manInTheMiddle(ctx context.Context, er EventReader, ew EventWriter, allCombos []*Combo, fakeActiveTimer bool) (reterr error) {
+// manInTheMiddle reads the events, and writes the modified events. ctl connects the engine with
+// the reloader and the control socket. ctl can be nil.
+func manInTheMiddle(ctx context.Context, er EventReader, ew EventWriter, config *Config, clock Clock,
+	ctl *engineControl,
+) (reterr error) {
 	defer func() {
 		if errors.Is(reterr, io.EOF) {
 			reterr = nil
 		}
 	}()
 
-	maxLength := 0
-	for i := range allCombos {
-		l := len(allCombos[i].Keys)
-		if l > maxLength {
-			maxLength = l
-		}
-	}
-	if maxLength == 0 {
+	if config.isEmpty() {
 		return fmt.Errorf("No combo contains keys")
 	}
-	state := NewState(maxLength, ew, allCombos)
-	state.fakeActiveTimer = fakeActiveTimer
+	state := NewState(config, ew)
+	defer state.printBlockedCounts()
+	defer flushStats()
 	type eventAndErr struct {
-		evP *Event
-		err error
+		evP    *Event
+		device string
+		err    error
 	}
 	eventChannel := make(chan eventAndErr)
 	go func() {
 		for {
-			evP, err := er.ReadOne()
-			eventChannel <- eventAndErr{evP, err}
+			evP, device, err := readOneWithDevice(er)
+			eventChannel <- eventAndErr{evP, device, err}
 			if err != nil {
 				return
 			}
 		}
 	}()
+	var timer <-chan time.Time
+	timerDeadline := maxTime
+	var pendingConfig *Config
+	var configs <-chan *Config
+	var calls <-chan func(*State)
+	if ctl != nil {
+		configs, calls = ctl.configs, ctl.calls
+	}
 	for {
+		if pendingConfig != nil && state.idle() {
+			state.setConfig(pendingConfig)
+			pendingConfig = nil
+			fmt.Printf("\nUsing the new config\n")
+		}
+		if state.pausePending && state.idle() {
+			state.pausePending = false
+			state.paused = true
+			fmt.Printf("\nPaused: all keys get passed through\n")
+		}
+		if deadline := state.nextDeadline(); !deadline.Equal(timerDeadline) {
+			timerDeadline = deadline
+			timer = clock.Timer(deadline)
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -443,7 +532,11 @@ func manInTheMiddle(ctx context.Context, er EventReader, ew EventWriter, allComb
 
 			if err != nil {
 				if errors.Is(err, io.EOF) {
-					err = errors.Join(err, state.FlushBuffer("EOF"))
+					if state.tapHold != nil {
+						err = errors.Join(err, state.decideTapHold(false, "EOF"))
+					}
+					err = errors.Join(err, state.afterTrainingTimer(maxTime), state.cancelSequence("EOF"),
+						state.FlushBuffer("EOF"), state.afterOutputTimer(maxTime))
 				}
 				return err
 			}
@@ -452,21 +545,41 @@ func manInTheMiddle(ctx context.Context, er EventReader, ew EventWriter, allComb
 				// It makes the endless loop stop without the final FlushBuffer.
 				return io.EOF
 			}
-			if state.fakeActiveTimer && state.fakeActiverTimerNextTime.Before(syscallTimevalToTime(evP.Time)) {
-				if err := state.fakeAfterTimerFunc(state.fakeActiverTimerNextTime); err != nil {
-					return err
-				}
-				state.fakeActiverTimerNextTime = maxTime
+			if err := state.fireTimers(syscallTimevalToTime(evP.Time)); err != nil {
+				return err
 			}
 
 			fmt.Printf("\n|>>%s", eventToCsvLine(*evP))
+			state.trackEmergencyChord(evP)
+			state.applyScanCode(evP, eventErr.device)
+			if passed, err := state.passThrough(evP); passed || err != nil {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			blocked := state.dropBlocked(evP, eventErr.device)
+			state.countKeyPress(evP, blocked)
+			if blocked || state.handleTraining(evP, eventErr.device) {
+				continue
+			}
+			state.setKeyDevice(evP, eventErr.device)
 
 			err = manInTheMiddleInnerLoop(evP, ew, state)
 			if err != nil {
 				return err
 			}
-		case <-state.activeTimer:
-			if err := state.AfterTimer(); err != nil {
+		case call := <-calls:
+			call(state)
+		case newConfig := <-configs:
+			if !state.idle() {
+				fmt.Printf("\nNew config: waiting until no key is pending or held\n")
+			}
+			pendingConfig = newConfig
+		case <-timer:
+			until := timerDeadline.Add(time.Nanosecond)
+			timerDeadline = maxTime
+			if err := state.fireTimers(until); err != nil {
 				return err
 			}
 		}
@@ -477,37 +590,58 @@ func manInTheMiddleInnerLoop(evP *Event, ew EventWriter, state *State) error {
 	var err error
 	if evP.Type != evdev.EV_KEY {
 		// fmt.Printf(" skipping %s\n", evP.String())
-		err = ew.WriteOne(evP)
+		if err := state.flushButtonBeforeMotion(evP); err != nil {
+			return err
+		}
+		err = state.writeOrQueueInput(ew, *evP)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
-	switch evP.Value {
-	case UP:
-		err = state.HandleUpChar(*evP)
-	case DOWN:
-		err = state.HandleDownChar(*evP)
-	case REPEAT:
-		fmt.Printf(" skipping (repeat): %s\n", evP.String())
-		return nil
-	default:
-		return fmt.Errorf("Received %d. Expected UP or DOWN", evP.Value)
+	if evP.Value == REPEAT {
+		return state.HandleRepeat(*evP)
 	}
+	state.trackRepeat(*evP)
+	err = state.HandleKey(*evP)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func NewState(maxLength int, ew EventWriter, allCombos []*Combo) *State {
+func NewState(config *Config, ew EventWriter) *State {
 	s := State{
-		outDev:             ew,
-		allCombos:          allCombos,
-		minOverlapDuration: 80 * time.Millisecond,
-	}
-	s.buf = make([]Event, 0, maxLength)
-	s.fakeActiverTimerNextTime = maxTime
+		outDev:            ew,
+		config:            config,
+		layerStack:        []*Layer{config.BaseLayer()},
+		pressedRemaps:     make(map[KeyCode]*Remap),
+		keysDown:          make(map[KeyCode]bool),
+		keyDevices:        make(map[KeyCode]string),
+		disabledGroups:    make(map[string]bool),
+		passthroughKeys:   make(map[KeyCode]bool),
+		emergencyKeysDown: make(map[KeyCode]bool),
+		pendingScanCodes:  make(map[string]uint32),
+		scanKeysDown:      make(map[scanKeyOfDevice]KeyCode),
+		scanOriginalKeys:  make(map[KeyCode]KeyCode),
+		scanCodesOfConfig: config.scanCodes(),
+		buttonsOfConfig:   config.buttons(),
+		trainingKeys:      config.trainingKeys(),
+		trainingWarnings:  make(map[KeyCode]int),
+		trainingBlocked:   make(map[KeyCode]bool),
+		runner:            execRunner{},
+		timing:            config.Timing.withDefaults(DefaultTiming),
+	}
+	s.updateCombosOfActiveLayer()
+	s.buf = make([]Event, 0, config.maxComboLength())
+	s.evalDeadline = maxTime
+	s.repeatDeadline = maxTime
+	s.emergencyDeadline = maxTime
+	s.mouseDeadline = maxTime
+	s.outputDeadline = maxTime
+	s.trainingDeadline = maxTime
+	s.macros = newMacroRecorder(ew)
+	s.outDev = s.macros
 	return &s
 }
 
@@ -515,50 +649,98 @@ func NewState(maxLength int, ew EventWriter, allCombos []*Combo) *State {
 var maxTime = time.Unix(1<<63-62135596801, 999999999)
 
 type State struct {
-	buf                      []Event
-	allCombos                []*Combo
-	downKeysWritten          []*Combo
-	swallowKeys              []KeyCode
-	minOverlapDuration       time.Duration
-	outDev                   EventWriter
-	activeTimer              <-chan time.Time // fires N milliseconds after the last key-down-event.
-	fakeActiveTimer          bool             // In tests the activeTimer will be faked by reading the time of the next event.
-	fakeActiverTimerNextTime time.Time        // The next the fakeActiveTimer event will be fired.
+	buf                 []Event
+	config              *Config
+	layerStack          []*Layer           // active layers. The first is the base layer, the last is the active layer.
+	combosLayer         *Layer             // the layer of the combos. Can differ from the active layer until the buffer is empty.
+	pressedRemaps       map[KeyCode]*Remap // remaps of keys which are currently pressed.
+	tapHold             *pendingTapHold    // tap-hold key which is down, but undecided yet.
+	sequence            *pendingSequence   // sequence which is in progress.
+	sequenceSwallowUps  []KeyCode          // keys of finished sequences. Their up-events get swallowed.
+	downKeysWritten     []*Combo
+	swallowKeys         []KeyCode
+	timing              Timing    // global timing. Combos can override it.
+	tooYoungUntil       time.Time // set by Eval, if a combo is too young. maxTime otherwise.
+	outDev              EventWriter
+	evalDeadline        time.Time                   // Eval gets called at this time. Set N milliseconds after the last key-down-event. maxTime means: not active.
+	keysDown            map[KeyCode]bool            // keys which were written down, but not up yet.
+	repeatingKey        KeyCode                     // the key which was pressed last. Only used, if Repeat is configured.
+	repeatDeadline      time.Time                   // the next repeat event gets written at this time. maxTime means: not active.
+	outputQueue         []queuedEvent               // output which waits for a delay or for pacing.
+	outputDeadline      time.Time                   // the first event of outputQueue gets written at this time. maxTime, if the queue is empty.
+	passedModifiers     []KeyCode                   // modifiers which were passed to the output without entering the buffer.
+	deferredModifierUps []Event                     // up-events of passed modifiers. Written when the buffer contains no undecided keys.
+	consumedModifiers   []KeyCode                   // modifiers which were released by a combo with Modifiers.
+	runner              CommandRunner               // executes the commands of combos with Run.
+	keyDevices          map[KeyCode]string          // the device of each pressed key. Only set, if 'devices' are configured.
+	blockedCounts       map[KeyCode]int             // how often each blocked key was pressed. Only set, if CountBlocked is true.
+	disabledGroups      map[string]bool             // combo groups which were disabled via the control socket.
+	pausePending        bool                        // pause, as soon as the engine is idle.
+	paused              bool                        // all keys get passed through.
+	passthroughKeys     map[KeyCode]bool            // keys which were pressed while paused. They get passed through until released.
+	emergencyKeysDown   map[KeyCode]bool            // keys of the emergency chord which are down on the devices.
+	emergencyDeadline   time.Time                   // the emergency chord was held long enough. maxTime, if not all keys are down.
+	pendingScanCodes    map[string]uint32           // MSC_SCAN of the current SYN frame of each device.
+	scanKeysDown        map[scanKeyOfDevice]KeyCode // keys which were replaced by the synthetic key code of their scan code.
+	scanOriginalKeys    map[KeyCode]KeyCode         // synthetic key code to the key code which was read from the device.
+	scanCodesOfConfig   map[uint32]KeyCode          // scan codes which are used in the config.
+	heldMouseActions    []*heldMouseAction          // mouse movements and scrolls of keys which are held.
+	mouseDeadline       time.Time                   // the next movement or scroll. maxTime, if no mouse action is held.
+	buttonsOfConfig     map[KeyCode]bool            // buttons which are used in combos or sequences.
+	macros              *macroRecorder              // wraps the EventWriter. Records and plays macros.
+	trainingKeys        map[KeyCode]*Combo          // keys which a combo produces. Used by the training mode.
+	trainingWarnings    map[KeyCode]int             // how often each training key was pressed directly.
+	trainingBlocked     map[KeyCode]bool            // keys which were blocked by the training mode. Their up-event gets dropped, too.
+	trainingDelayed     []delayedEvent              // events of training keys which wait for their delay.
+	trainingDeadline    time.Time                   // the first event of trainingDelayed is due. maxTime, if no event is delayed.
 }
 
-func (state *State) Eval(time syscall.Timeval, reason string) error {
+func (state *State) Eval(time syscall.Timeval, reason string) (reterr error) {
 	fmt.Printf("Eval [%s] %s\n", reason, state.String())
+	defer func() {
+		if reterr == nil {
+			reterr = state.writeDeferredModifierUps()
+		}
+	}()
+	if state.tapHold != nil {
+		return state.evalTapHold(syscallTimevalToTime(time))
+	}
+	if state.sequence != nil {
+		return state.evalSequence(syscallTimevalToTime(time))
+	}
+	state.tooYoungUntil = maxTime
+	defer func() {
+		// The timer fired, but a combo with a bigger minAge is still too young.
+		// Evaluate again, when it is old enough.
+		if reason == "timer" && state.tooYoungUntil != maxTime {
+			currTime := syscallTimevalToTime(time)
+			state.startTimer(time, state.tooYoungUntil.Sub(currTime))
+		}
+	}()
+	if state.swallowReleasedKeys() && len(state.buf) == 0 {
+		return nil
+	}
 	if len(state.buf) == 2 &&
 		state.buf[0].Code == state.buf[1].Code &&
 		state.buf[0].Value == DOWN && state.buf[1].Value == UP {
-		newSwallowKeys := make([]KeyCode, 0, len(state.swallowKeys))
-		doSwallow := false
-		for _, key := range state.swallowKeys {
-			if state.buf[0].Code == key && state.buf[1].Code == key {
-				doSwallow = true
-				continue
-			}
-			newSwallowKeys = append(newSwallowKeys, key)
-		}
-		if doSwallow {
-			state.swallowKeys = newSwallowKeys
-			fmt.Printf("  SwallowKeys: %s\n", state.String())
-			state.buf = nil
-			return nil
-		}
 		state.FlushBuffer("Eval>up-down-of-singlechar")
 		return nil
 	}
-	combos := state.allCombos
+	combos := state.comboCandidates()
 	codes := make([]evalResult, 0, len(combos))
+	var nearMisses []nearMiss
 	for _, combo := range combos {
 		code, msg, err := state.EvalCombo(combo, time)
 		if err != nil {
 			return fmt.Errorf("failed to eval combo: %w", err)
 		}
-		fmt.Printf("  EvalCombo %s %s: %s\n", combo.String(), code, msg)
+		if reason := nearMissOf(msg); code == NoMatch && reason != "" {
+			nearMisses = append(nearMisses, nearMiss{combo, reason})
+		}
+		fmt.Printf("  EvalCombo %s [%s] %s: %s\n", combo.String(), state.comboTiming(combo).String(), code, msg)
 		codes = append(codes, code)
 	}
+	preferCombosWithModifiers(combos, codes)
 	// Handle WriteUpKeys first
 	found := false
 	for i, code := range codes {
@@ -612,9 +794,35 @@ func (state *State) Eval(time syscall.Timeval, reason string) error {
 	}
 
 	// no match. Flush buffer.
+	state.countNearMisses(nearMisses, syscallTimevalToTime(time))
 	return state.FlushBuffer("Eval>No-match")
 }
 
+// swallowReleasedKeys removes the keys of a finished combo from the buffer, as soon as they
+// get released. Returns true if keys were removed.
+func (state *State) swallowReleasedKeys() bool {
+	swallowed := false
+	for _, key := range state.swallowKeys {
+		downIndex := slices.IndexFunc(state.buf, func(ev Event) bool {
+			return ev.Code == key && ev.Value == DOWN
+		})
+		upIndex := slices.IndexFunc(state.buf, func(ev Event) bool {
+			return ev.Code == key && ev.Value == UP
+		})
+		if downIndex == -1 || upIndex == -1 || upIndex < downIndex {
+			continue
+		}
+		state.buf = slices.Delete(state.buf, upIndex, upIndex+1)
+		state.buf = slices.Delete(state.buf, downIndex, downIndex+1)
+		state.swallowKeys = removeFromSlice(state.swallowKeys, key)
+		swallowed = true
+	}
+	if swallowed {
+		fmt.Printf("  SwallowKeys: %s\n", state.String())
+	}
+	return swallowed
+}
+
 type evalResult string
 
 var (
@@ -627,6 +835,13 @@ var (
 )
 
 func (state *State) EvalCombo(combo *Combo, currTime syscall.Timeval) (evalResult, string, error) {
+	if !state.modifiersHeld(combo) && !slices.Contains(state.downKeysWritten, combo) {
+		// The modifiers are only needed to start the combo.
+		return NoMatch, "Modifiers not held", nil
+	}
+	if state.disabledGroups[combo.Group] && !slices.Contains(state.downKeysWritten, combo) {
+		return NoMatch, fmt.Sprintf("Group %q is disabled", combo.Group), nil
+	}
 	// check if all down-keys are seen, and in the same order.
 	seenDown := make([]KeyCode, 0, len(combo.Keys))
 	seenUp := make([]KeyCode, 0, len(combo.Keys))
@@ -657,17 +872,23 @@ func (state *State) EvalCombo(combo *Combo, currTime syscall.Timeval) (evalResul
 	if unknownKey != nil {
 		return NoMatch, "Unknown key in buffer: " + keyToString(*unknownKey), nil
 	}
+	if key, found := state.keyFromOtherDevice(combo); found {
+		return NoMatch, "Key from other device: " + keyToString(key), nil
+	}
 
-	for i, key := range combo.Keys {
+	for i := range combo.Keys {
 		if i >= len(seenDown) {
 			// Not all down-keys are seen.
 			return ComboNotFinished,
 				fmt.Sprintf("seenDown %s", SliceOfKeysToString(seenDown)),
 				nil
 		}
-		if seenDown[i] != key {
+		if slices.Contains(seenDown[:i], seenDown[i]) {
+			return NoMatch, fmt.Sprintf("Key pressed twice %s", SliceOfKeysToString(seenDown)), nil
+		}
+		if !combo.keyAllowedAt(i, seenDown[i]) {
 			// Order is wrong. For example "J F" instead of "F J".
-			return NoMatch, fmt.Sprintf("Order is wrong %s", SliceOfKeysToString(seenDown)), nil
+			return NoMatch, fmt.Sprintf("%s %s", nearMissOrder, SliceOfKeysToString(seenDown)), nil
 		}
 	}
 
@@ -678,11 +899,11 @@ func (state *State) EvalCombo(combo *Combo, currTime syscall.Timeval) (evalResul
 		lastDownEvent.Code != firstUpEvent.Code {
 
 		overlapDuration := timeSub(*&lastDownEvent.Time, firstUpEvent.Time)
-		if overlapDuration < 40*time.Millisecond {
-			return NoMatch, fmt.Sprintf("Overlap too short %s", overlapDuration), nil
+		if overlapDuration < state.comboTiming(combo).MinOverlap {
+			return NoMatch, fmt.Sprintf("%s %s", nearMissOverlap, overlapDuration), nil
 		}
 	}
-	isTooYoung := tooYoung(state, lastDownEvent, currTime)
+	isTooYoung := tooYoung(state, combo, lastDownEvent, currTime)
 	if isTooYoung != "" {
 		// All in-down-keys are seen. But wait some milliseconds before writing the out-down-keys.
 		return ComboNotFinished, isTooYoung, nil
@@ -696,33 +917,24 @@ func (state *State) EvalCombo(combo *Combo, currTime syscall.Timeval) (evalResul
 	return AllDownKeysSeen, "All down seen. Write the out-down-keys", nil
 }
 
-func tooYoung(state *State, lastDownEvent *evdev.InputEvent, currTime syscall.Timeval) string {
+func tooYoung(state *State, combo *Combo, lastDownEvent *evdev.InputEvent, currTime syscall.Timeval) string {
 	if len(state.buf) > 1 {
 		if state.buf[len(state.buf)-2].Code == lastDownEvent.Code {
 			return ""
 		}
 	}
 	age := timeSub(lastDownEvent.Time, currTime)
-	minAge := 140 * time.Millisecond
+	minAge := state.comboTiming(combo).MinAge
 	if age < minAge {
+		oldEnough := syscallTimevalToTime(lastDownEvent.Time).Add(minAge)
+		if oldEnough.Before(state.tooYoungUntil) {
+			state.tooYoungUntil = oldEnough
+		}
 		return fmt.Sprintf("All down seen, but too young (lastDown..currTime minAge %s): %s", minAge.String(), age.String())
 	}
 	return ""
 }
 
-// AfterTimer gets called N milliseconds after the last key-down-event.
-// If all down-keys got pressed (and held down, no up-keys were seen yet),
-// then we need to fire the down events after some time.
-func (state *State) AfterTimer() error {
-	timeval := syscall.Timeval{}
-	syscall.Gettimeofday(&timeval)
-	return state.Eval(timeval, "timer")
-}
-
-func (state *State) fakeAfterTimerFunc(time time.Time) error {
-	return state.Eval(timeToSyscallTimeval(time), "timer")
-}
-
 func (state *State) WriteComboDownKeysNew(combo *Combo) error {
 	if slices.Contains(state.downKeysWritten, combo) {
 		// Down-Keys have already been written.
@@ -770,24 +982,87 @@ type upDownValue = int32
 
 func (state *State) WriteCombo(combo *Combo, time syscall.Timeval, value upDownValue) error {
 	// first match. Use that timestamp to write out the combo.
-	for _, outKey := range combo.OutKeys {
-		err := state.WriteEvent(evdev.InputEvent{
-			Time:  time,
-			Type:  evdev.EV_KEY,
-			Code:  outKey,
-			Value: value,
-		}, fmt.Sprintf("WriteCombo %s %s", eventValueToString[value], combo.String()))
-		if err != nil {
-			return fmt.Errorf("failed to write DOWN event: %w", err)
+	if len(combo.Modifiers) > 0 && value == DOWN {
+		if err := state.consumeModifiers(combo, Event{Time: time, Type: evdev.EV_KEY}); err != nil {
+			return err
 		}
 	}
+	var err error
+	if combo.Output != nil {
+		err = state.writeOutputSteps(combo, time, value)
+	} else {
+		_, err = state.writeChord(combo, combo.OutKeys, time, state.outputTime(syscallTimevalToTime(time)), value)
+	}
+	if err != nil {
+		return err
+	}
+	if len(combo.Modifiers) > 0 && value == UP {
+		if err := state.restoreModifiers(Event{Time: time, Type: evdev.EV_KEY}); err != nil {
+			return err
+		}
+	}
+	state.runCombo(combo, value)
+	if value == DOWN {
+		state.countCombo(combo, syscallTimevalToTime(time))
+	}
+	if combo.Macro != nil {
+		if err := state.handleMacroAction(combo.Macro, value, time); err != nil {
+			return err
+		}
+	}
+	if combo.Mouse != nil {
+		return state.handleMouseAction(combo.Mouse, value, time)
+	}
 	return nil
 }
 
+// WriteEvent writes an event which is not part of the output of a combo. For example a key of the
+// input, which gets passed through.
 func (state *State) WriteEvent(ev Event, reason string) error {
-	fmt.Printf("  write %s %s\n", eventToString(&ev), reason)
-	err := state.outDev.WriteOne(&ev)
-	return errors.Join(err, state.outDev.WriteOne(&Event{
+	if ev.Type == evdev.EV_KEY && ev.Value == UP {
+		if i := slices.Index(state.consumedModifiers, ev.Code); i != -1 {
+			// The modifier was already released by a combo. Do not press it again.
+			state.consumedModifiers = slices.Delete(state.consumedModifiers, i, i+1)
+			fmt.Printf("  skip %s %s (already released by a combo)\n", eventToString(&ev, state.config.Layout), reason)
+			return nil
+		}
+	}
+	return state.writeEventAt(ev, state.outputTime(syscallTimevalToTime(ev.Time)), reason)
+}
+
+// writeEventAt writes the event at the time at. ev.Time is the current time. If at is later, or if
+// output is queued already, the event gets queued (see queueOutput).
+func (state *State) writeEventAt(ev Event, at time.Time, reason string) error {
+	if ev.Type == evdev.EV_KEY {
+		switch ev.Value {
+		case DOWN:
+			state.keysDown[ev.Code] = true
+		case UP:
+			delete(state.keysDown, ev.Code)
+		}
+	}
+	if ev.Type == evdev.EV_KEY {
+		ev.Code = state.originalKey(ev.Code)
+	}
+	return state.queueOrWrite(state.outDev, ev, at, reason)
+}
+
+// queueOrWrite writes the event to ew. ev.Time is the current time. If at is later, or if output
+// is queued already, the event gets queued (see queueOutput).
+func (state *State) queueOrWrite(ew EventWriter, ev Event, at time.Time, reason string) error {
+	if now := syscallTimevalToTime(ev.Time); len(state.outputQueue) > 0 || at.After(now) {
+		fmt.Printf("  queue %s %s (in %s)\n", eventToString(&ev, state.config.Layout), reason, at.Sub(now))
+		state.queueOutput(ew, ev, at)
+		return nil
+	}
+	fmt.Printf("  write %s %s\n", eventToString(&ev, state.config.Layout), reason)
+	return writeOut(ew, ev)
+}
+
+// writeOut writes the event and a SYN_REPORT.
+func writeOut(ew EventWriter, ev Event) error {
+	err := ew.WriteOne(&ev)
+	return errors.Join(err, ew.WriteOne(&Event{
 		Time:  ev.Time,
 		Type:  evdev.EV_SYN,
 		Code:  evdev.SYN_REPORT,
@@ -807,7 +1082,7 @@ func (state *State) String() string {
 		if prev != nil {
 			ret = append(ret, fmt.Sprintf("(%s)", timeSub(prev.Time, ev.Time).String()))
 		}
-		ret = append(ret, eventToString(&ev))
+		ret = append(ret, eventToString(&ev, state.config.Layout))
 		prev = &ev
 	}
 	if len(ret) == 0 {
@@ -832,9 +1107,8 @@ func (state *State) FlushBuffer(reason string) error {
 		}
 	}
 	state.buf = nil
-	state.activeTimer = nil
-	state.fakeActiverTimerNextTime = maxTime
-	return nil
+	state.evalDeadline = maxTime
+	return state.writeDeferredModifierUps()
 }
 
 func (state *State) FlushBufferAndWriteEvent(ev Event, reason string) error {
@@ -855,19 +1129,14 @@ func (state *State) HandleUpChar(
 func (state *State) HandleDownChar(
 	ev Event,
 ) error {
-	timeoutAfterDownDuration := 150 * time.Millisecond
-	if state.fakeActiveTimer {
-		// For testing.
-		state.fakeActiverTimerNextTime = syscallTimevalToTime(ev.Time).Add(timeoutAfterDownDuration)
-	} else {
-		state.activeTimer = time.After(timeoutAfterDownDuration)
-	}
-
+	// The buffer could have been flushed since the layer changed, for example by a remapped key.
+	state.updateCombosOfActiveLayer()
 	state.buf = append(state.buf, ev)
+	state.startTimer(ev.Time, state.candidatesTimeout())
 	return state.Eval(ev.Time, "down")
 }
 
-func printEvents(sourceDevice *evdev.InputDevice) error {
+func printEvents(sourceDevice *evdev.InputDevice, layout *keyboardLayout) error {
 	defer sourceDevice.Close()
 	sourceDevice.Grab()
 	targetName, err := sourceDevice.Name()
@@ -888,6 +1157,8 @@ func printEvents(sourceDevice *evdev.InputDevice) error {
 		eventChannel: make(chan *ReadResult),
 	}
 	go source.readAndWriteForever()
+	var scanCode int32
+	hasScanCode := false
 	for {
 		ev, timedOut, err := source.getOneEventOrTimeout(time.Duration(time.Second))
 		if err != nil {
@@ -908,6 +1179,14 @@ func printEvents(sourceDevice *evdev.InputDevice) error {
 			}
 			continue
 		}
+		switch {
+		case ev.Type == evdev.EV_MSC && ev.Code == evdev.MSC_SCAN:
+			// The scan code belongs to the EV_KEY event of the same SYN frame.
+			scanCode = ev.Value
+			hasScanCode = true
+		case ev.Type == evdev.EV_SYN:
+			hasScanCode = false
+		}
 		if eventToSkip(ev) {
 			continue
 		}
@@ -924,7 +1203,10 @@ func printEvents(sourceDevice *evdev.InputDevice) error {
 		var s string
 		switch ev.Type {
 		case evdev.EV_KEY:
-			s = eventToString(ev)
+			s = eventToString(ev, layout)
+			if hasScanCode {
+				s += fmt.Sprintf(" (%s)", scanCodeName(uint32(scanCode)))
+			}
 		default:
 			s = ev.String()
 		}
@@ -988,13 +1270,21 @@ var shortKeyNames = map[string]string{
 	"rightshift": " ⇧",
 }
 
-func eventToString(ev *Event) string {
+// eventToString returns a short string like "f_". If layout is not nil, the character of the
+// keycap gets used instead of the name of the evdev code.
+func eventToString(ev *Event, layout *keyboardLayout) string {
+	if ev.Type == evdev.EV_REL {
+		return fmt.Sprintf("%s%+d", strings.ToLower(strings.TrimPrefix(ev.CodeName(), "REL_")), ev.Value)
+	}
 	if ev.Type != evdev.EV_KEY {
 		return fmt.Sprintf("[err: need a EV_KEY event. Got %s]", ev.String())
 	}
-	name := ev.CodeName()
-	name = strings.TrimPrefix(name, "KEY_")
-	name = strings.ToLower(name)
+	name := layout.keycap(ev.Code)
+	if name == "" {
+		name = keyCodeName(ev.Code)
+		name = strings.TrimPrefix(name, "KEY_")
+		name = strings.ToLower(name)
+	}
 	short, ok := shortKeyNames[name]
 	if ok {
 		name = short
@@ -1045,7 +1335,7 @@ func eventToCsvLine(ev Event) string {
 		value = fmt.Sprint(ev.Value)
 	}
 	return fmt.Sprintf("%d;%d;%s;%s;%s\n", ev.Time.Sec, ev.Time.Usec,
-		ev.TypeName(), ev.CodeName(),
+		ev.TypeName(), buttonName(ev.CodeName()),
 		value)
 }
 
@@ -1088,26 +1378,16 @@ func (c *ComboLogEventReader) ReadOne() (*Event, error) {
 
 var sleepAfterOpenFailure = 5 * time.Second
 
-func handleOneDevice(ctx context.Context, combos []*Combo, dev *device, errorChannel chan error) {
-	for {
-		if dev.sourceDev == nil {
-			err := dev.Open()
-			if err != nil {
-				fmt.Printf("failed to open %q. Will wait %s. %s\n", dev.path, sleepAfterOpenFailure, err.Error())
-
-				// sleep, but check if the context is done.
-				select {
-				case <-ctx.Done():
-					errorChannel <- ctx.Err()
-					return
-				case <-time.After(sleepAfterOpenFailure):
-				}
-			}
-			continue
+func handleOneDevice(ctx context.Context, config *Config, ctl *engineControl, dev *device,
+	errorChannel chan error,
+) {
+	if dev.sourceDev == nil {
+		if err := retryOpen(ctx, dev.path, dev.Open); err != nil {
+			errorChannel <- err
+			return
 		}
-		errorChannel <- manInTheMiddle(ctx, dev.sourceDev, dev.outDev, combos, false)
-		return
 	}
+	errorChannel <- manInTheMiddle(ctx, dev.sourceDev, dev.outDev, config, RealClock{}, ctl)
 }
 
 func removeFromSlice[T comparable](s []T, elem T) []T {
@@ -68,19 +68,35 @@ func AssertComboStateStringInputOutput(t *testing.T, input string, expectedOutpu
 	_assertInputOutput(t, input, expectedOutput, allCombos, NewReadFromSliceInputStateString)
 }
 
+// AssertYamlStateStringInputOutput is like AssertComboStateStringInputOutput, but the config
+// gets loaded from the yaml string.
+func AssertYamlStateStringInputOutput(t *testing.T, input string, expectedOutput string, yamlString string) {
+	t.Helper()
+	config, err := LoadYamlFromBytes([]byte(yamlString))
+	require.NoError(t, err)
+	_assertConfigInputOutput(t, input, expectedOutput, config, NewReadFromSliceInputStateString)
+}
+
 func _assertInputOutput(t *testing.T, input string, expectedOutput string, allCombos []*Combo,
 	stringToEventsFunc func(string) (*readFromSlice, error),
+) {
+	t.Helper()
+	_assertConfigInputOutput(t, input, expectedOutput, NewConfigFromCombos(allCombos), stringToEventsFunc)
+}
+
+func _assertConfigInputOutput(t *testing.T, input string, expectedOutput string, config *Config,
+	stringToEventsFunc func(string) (*readFromSlice, error),
 ) {
 	t.Helper()
 	ew := writeToSlice{}
 	er, err := stringToEventsFunc(input)
 	require.Nil(t, err)
-	err = manInTheMiddle(context.Background(), er, &ew, allCombos, true)
+	err = manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil)
 	require.NoError(t, err)
 	ew.requireEqual(t, expectedOutput)
 }
 
-var csvLineToShortLineRegex = regexp.MustCompile(`^\d+;\d+;EV_KEY;KEY_(\w+);(\w+)$`)
+var csvLineToShortLineRegex = regexp.MustCompile(`^\d+;\d+;EV_KEY;(?:KEY_)?(\w+);(\w+)$`)
 
 func csvLineToShortLine(csvLine string) (string, error) {
 	matches := csvLineToShortLineRegex.FindStringSubmatch(csvLine)
@@ -131,6 +147,8 @@ func stateStringToSlice(stateString string) ([]evdev.InputEvent, error) {
 				value = DOWN
 			case '/':
 				value = UP
+			case '=':
+				value = REPEAT
 			}
 			code, err := wordToKeyCode(part[:len(part)-1])
 			if err != nil {
@@ -204,7 +222,7 @@ func Test_manInTheMiddle_noMatch(t *testing.T) {
 		ew := writeToSlice{}
 		er, err := NewReadFromSliceInputCSV(asdfTestEvents)
 		require.Nil(t, err)
-		err = manInTheMiddle(context.Background(), er, &ew, allCombos, true)
+		err = manInTheMiddle(context.Background(), er, &ew, NewConfigFromCombos(allCombos), VirtualClock{}, nil)
 		require.NoError(t, err)
 		csv := eventsToCsv(ew.s)
 		require.Equal(t, asdfTestEvents, csv)
@@ -429,6 +447,22 @@ func Test_manInTheMiddle_Unrelated_Embraced_Keystrokes(t *testing.T) {
 		fjkCombos)
 }
 
+func Test_manInTheMiddle_ThreeKeyCombo_ReleasedInReverseOrder(t *testing.T) {
+	// After the combo was written, the released keys are not the first events of the buffer.
+	// They must get swallowed, too.
+	AssertYamlStateStringInputOutput(t,
+		`
+		d_ (20ms) k_ (20ms) l_ (200ms) l/ (10ms) k/ (10ms) d/ (300ms) x_ (20ms) x/
+	`,
+		`
+		Y-down
+		Y-up
+		X-down
+		X-up
+	`,
+		"combos:\n  - keys: d k l\n    outKeys: y\n")
+}
+
 func Test_manInTheMiddle_ComboWithMatch_NoPanic(t *testing.T) {
 	// This test is to ensure that no panic happens.
 	// Output could be different.
@@ -557,13 +591,13 @@ func Test_ShouldNotPanic(t *testing.T) {
 |>>1737965477;488608;EV_KEY;KEY_N;down`
 	scanner := bufio.NewScanner(strings.NewReader(string(log)))
 	logReader := ComboLogEventReader{scanner: scanner}
-	combos, err := LoadYamlFromBytes([]byte(`
+	config, err := LoadYamlFromBytes([]byte(`
 combos:
   - keys: capslock n
     outKeys: down`))
 	require.NoError(t, err)
 	ew := &writeToSlice{}
-	err = manInTheMiddle(context.Background(), &logReader, ew, combos, true)
+	err = manInTheMiddle(context.Background(), &logReader, ew, config, VirtualClock{}, nil)
 	require.NoError(t, err)
 }
 
@@ -572,13 +606,13 @@ func Test_FJX_emits_f_but_should_not(t *testing.T) {
 	require.NoError(t, err)
 	scanner := bufio.NewScanner(strings.NewReader(string(log)))
 	logReader := ComboLogEventReader{scanner: scanner}
-	combos, err := LoadYamlFromBytes([]byte(`
+	config, err := LoadYamlFromBytes([]byte(`
 combos:
   - keys: f j
     outKeys: x`))
 	require.NoError(t, err)
 	ew := &writeToSlice{}
-	err = manInTheMiddle(context.Background(), &logReader, ew, combos, true)
+	err = manInTheMiddle(context.Background(), &logReader, ew, config, VirtualClock{}, nil)
 	require.NoError(t, err)
 	ew.requireEqual(t, `
         	        	X-down
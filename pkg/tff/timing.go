@@ -0,0 +1,61 @@
+package tff
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timing contains the thresholds of the combo engine. A zero value means "not set". Then the
+// value of the next level is used: combo, top-level of combos.yaml, DefaultTiming.
+type Timing struct {
+	// MinOverlap: if the first key gets released less than MinOverlap after the last key was
+	// pressed, then this is fluent typing with some overlap, not a combo.
+	MinOverlap time.Duration
+
+	// MinAge: all keys of a combo must be down at least MinAge, before the out-down-keys get
+	// written.
+	MinAge time.Duration
+
+	// Timeout: the engine gets evaluated again Timeout after each key-down. If several combos
+	// start with the same key, the largest timeout gets used.
+	Timeout time.Duration
+}
+
+var DefaultTiming = Timing{
+	MinOverlap: 40 * time.Millisecond,
+	MinAge:     140 * time.Millisecond,
+	Timeout:    150 * time.Millisecond,
+}
+
+// withDefaults returns a copy. Values which are not set get taken from defaults.
+func (t Timing) withDefaults(defaults Timing) Timing {
+	if t.MinOverlap == 0 {
+		t.MinOverlap = defaults.MinOverlap
+	}
+	if t.MinAge == 0 {
+		t.MinAge = defaults.MinAge
+	}
+	if t.Timeout == 0 {
+		t.Timeout = defaults.Timeout
+	}
+	return t
+}
+
+func (t Timing) String() string {
+	return fmt.Sprintf("minOverlap=%s minAge=%s timeout=%s", t.MinOverlap, t.MinAge, t.Timeout)
+}
+
+// candidatesTimeout returns the largest timeout of the combos which start with the first key of
+// the buffer.
+func (state *State) candidatesTimeout() time.Duration {
+	timeout := state.timing.Timeout
+	for _, combo := range state.comboCandidates() {
+		timeout = max(timeout, state.comboTiming(combo).Timeout)
+	}
+	return timeout
+}
+
+// comboTiming returns the effective timing of the combo.
+func (state *State) comboTiming(combo *Combo) Timing {
+	return combo.Timing.withDefaults(state.timing)
+}
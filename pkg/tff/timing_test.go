@@ -0,0 +1,125 @@
+package tff
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Timing_GlobalMinOverlap(t *testing.T) {
+	input := `f_ (60ms) j_ (30ms) f/ (10ms) j/`
+
+	// 30ms overlap is too short with the default timing.
+	AssertYamlStateStringInputOutput(t, input,
+		`
+		F-down
+		J-down
+		F-up
+		J-up
+	`,
+		`
+combos:
+  - keys: f j
+    outKeys: x
+`)
+
+	AssertYamlStateStringInputOutput(t, input,
+		`
+		X-down
+		X-up
+	`,
+		`
+timing:
+  minOverlap: 20ms
+combos:
+  - keys: f j
+    outKeys: x
+`)
+}
+
+func Test_Timing_ComboOverridesMinOverlap(t *testing.T) {
+	yamlString := `
+combos:
+  - keys: f j
+    outKeys: x
+    timing:
+      minOverlap: 20ms
+  - keys: f k
+    outKeys: y
+`
+	AssertYamlStateStringInputOutput(t,
+		`f_ (60ms) j_ (30ms) f/ (10ms) j/`,
+		`
+		X-down
+		X-up
+	`, yamlString)
+
+	AssertYamlStateStringInputOutput(t,
+		`f_ (60ms) k_ (30ms) f/ (10ms) k/`,
+		`
+		F-down
+		K-down
+		F-up
+		K-up
+	`, yamlString)
+}
+
+func Test_Timing_MinAgeBiggerThanTimeout(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+timing:
+  timeout: 150ms
+combos:
+  - keys: f j
+    outKeys: x
+    timing:
+      minAge: 300ms
+`))
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	state := NewState(config, &ew)
+
+	start := time.Unix(1712500000, 0)
+	at := func(d time.Duration) syscall.Timeval {
+		return timeToSyscallTimeval(start.Add(d))
+	}
+	require.NoError(t, state.HandleKey(Event{Time: at(0), Type: evdev.EV_KEY, Code: evdev.KEY_F, Value: DOWN}))
+	require.NoError(t, state.HandleKey(Event{Time: at(20 * time.Millisecond), Type: evdev.EV_KEY, Code: evdev.KEY_J, Value: DOWN}))
+	require.Equal(t, start.Add(170*time.Millisecond), state.evalDeadline)
+
+	// The timer fires, but the combo is too young. The timer gets started again.
+	require.NoError(t, state.Eval(at(170*time.Millisecond), "timer"))
+	require.Empty(t, ew.s)
+	require.Equal(t, start.Add(320*time.Millisecond), state.evalDeadline)
+
+	require.NoError(t, state.Eval(at(320*time.Millisecond), "timer"))
+	ew.requireEqual(t, `X-down`)
+}
+
+func Test_Timing_ComboTimeout(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+combos:
+  - keys: f j
+    outKeys: x
+    timing:
+      timeout: 300ms
+  - keys: f k
+    outKeys: y
+  - keys: d k
+    outKeys: z
+`))
+	require.NoError(t, err)
+	require.Equal(t, 300*time.Millisecond, config.BaseLayer().Combos[0].Timing.Timeout)
+	state := NewState(config, &writeToSlice{})
+
+	start := time.Unix(1712500000, 0)
+	// Two combos start with f. The largest timeout gets used.
+	require.NoError(t, state.HandleKey(Event{Time: timeToSyscallTimeval(start), Type: evdev.EV_KEY, Code: evdev.KEY_F, Value: DOWN}))
+	require.Equal(t, start.Add(300*time.Millisecond), state.evalDeadline)
+	require.NoError(t, state.FlushBuffer("test"))
+
+	require.NoError(t, state.HandleKey(Event{Time: timeToSyscallTimeval(start), Type: evdev.EV_KEY, Code: evdev.KEY_D, Value: DOWN}))
+	require.Equal(t, start.Add(DefaultTiming.Timeout), state.evalDeadline)
+}
@@ -0,0 +1,239 @@
+package tff
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// The training mode reacts, if a key gets pressed directly, although a combo produces it. This
+// helps to get used to the combos.
+
+type TrainingAction string
+
+const (
+	// TrainingLog only logs the key.
+	TrainingLog TrainingAction = "log"
+
+	// TrainingHook runs a command, for example to play a sound. The environment variable TFF_KEY
+	// contains the name of the key.
+	TrainingHook TrainingAction = "hook"
+
+	// TrainingDelay delays the key.
+	TrainingDelay TrainingAction = "delay"
+
+	// TrainingBlock blocks the key after some warnings.
+	TrainingBlock TrainingAction = "block"
+)
+
+const (
+	DefaultTrainingDelay    = 300 * time.Millisecond
+	DefaultTrainingWarnings = 3
+)
+
+type Training struct {
+	Action TrainingAction
+
+	// Hook gets executed for TrainingHook.
+	Hook *RunAction
+
+	// Delay is the delay of TrainingDelay.
+	Delay time.Duration
+
+	// Warnings: TrainingBlock blocks the key, after it was pressed this often.
+	Warnings int
+}
+
+type yamlTraining struct {
+	Action   string        `yaml:"action"`
+	Run      *yamlRun      `yaml:"run"`
+	Delay    time.Duration `yaml:"delay"`
+	Warnings int           `yaml:"warnings"`
+}
+
+func yamlTrainingToTraining(y *yamlTraining) (*Training, error) {
+	if y == nil {
+		return nil, nil
+	}
+	training := Training{
+		Action:   TrainingAction(y.Action),
+		Delay:    y.Delay,
+		Warnings: y.Warnings,
+	}
+	if y.Delay < 0 || y.Warnings < 0 {
+		return nil, fmt.Errorf("negative values in 'training' are not allowed.")
+	}
+	switch training.Action {
+	case "":
+		training.Action = TrainingLog
+	case TrainingLog, TrainingHook, TrainingDelay, TrainingBlock:
+	default:
+		return nil, fmt.Errorf("invalid 'action: %s' in 'training'. Valid values: %s, %s, %s, %s", y.Action,
+			TrainingLog, TrainingHook, TrainingDelay, TrainingBlock)
+	}
+	if (training.Action == TrainingHook) != (y.Run != nil) {
+		return nil, fmt.Errorf("'training' needs 'run', if the action is %q. Otherwise 'run' is not allowed.",
+			TrainingHook)
+	}
+	if y.Run != nil {
+		var err error
+		training.Hook, err = yamlRunToRunAction(*y.Run)
+		if err != nil {
+			return nil, fmt.Errorf("training: %w", err)
+		}
+	}
+	if training.Action == TrainingDelay && training.Delay == 0 {
+		training.Delay = DefaultTrainingDelay
+	}
+	if training.Action == TrainingBlock && training.Warnings == 0 {
+		training.Warnings = DefaultTrainingWarnings
+	}
+	return &training, nil
+}
+
+// trainingKeys returns the keys which a combo or sequence produces, and which should not be
+// pressed directly. Only combos which produce a single key get used. Keys which are part of a
+// combo do not get trained.
+func (c *Config) trainingKeys() map[KeyCode]*Combo {
+	trainingKeys := make(map[KeyCode]*Combo)
+	var inputKeys []KeyCode
+	for _, layer := range c.Layers {
+		combos := slices.Clone(layer.Combos)
+		for _, seq := range layer.Sequences {
+			combos = append(combos, &seq.Combo)
+		}
+		for _, combo := range combos {
+			inputKeys = append(inputKeys, combo.Keys...)
+			if len(combo.OutKeys) != 1 || combo.Output != nil || isModifier(combo.OutKeys[0]) {
+				continue
+			}
+			if _, ok := trainingKeys[combo.OutKeys[0]]; !ok {
+				trainingKeys[combo.OutKeys[0]] = combo
+			}
+		}
+	}
+	for _, key := range inputKeys {
+		delete(trainingKeys, key)
+	}
+	return trainingKeys
+}
+
+// delayedEvent is an event of a training key, which gets handled at the time at.
+type delayedEvent struct {
+	ev     Event
+	device string
+	at     time.Time
+}
+
+// handleTraining returns true, if the event should be dropped, or if it gets delayed.
+func (state *State) handleTraining(ev *Event, device string) bool {
+	if ev.Type != evdev.EV_KEY {
+		return false
+	}
+	delay, drop := state.checkTraining(ev)
+	if drop {
+		return true
+	}
+	return state.delayTrainingEvent(ev, device, delay)
+}
+
+// checkTraining counts the warnings of a training key and runs the action of the training mode.
+// Returns the delay of the event, and true, if the event should be dropped.
+func (state *State) checkTraining(ev *Event) (time.Duration, bool) {
+	training := state.config.Training
+	if ev.Value != DOWN {
+		// Up and repeat of a blocked key get dropped, too.
+		if !state.trainingBlocked[ev.Code] {
+			return 0, false
+		}
+		if ev.Value == UP {
+			delete(state.trainingBlocked, ev.Code)
+		}
+		return 0, true
+	}
+	if training == nil {
+		return 0, false
+	}
+	combo, ok := state.trainingKeys[ev.Code]
+	if !ok {
+		return 0, false
+	}
+	state.trainingWarnings[ev.Code]++
+	warnings := state.trainingWarnings[ev.Code]
+	now := syscallTimevalToTime(ev.Time)
+	state.updateStats(now, func(stats *Stats) {
+		day := now.Format(time.DateOnly)
+		if stats.Training[day] == nil {
+			stats.Training[day] = make(map[string]int)
+		}
+		stats.Training[day][keyToString(ev.Code)]++
+	})
+	fmt.Printf("Training: %s was pressed directly. Use the combo %s. Warning %d\n",
+		keyToString(ev.Code), combo.String(), warnings)
+	switch training.Action {
+	case TrainingHook:
+		hook := *training.Hook
+		hook.Env = maps.Clone(hook.Env)
+		if hook.Env == nil {
+			hook.Env = make(map[string]string)
+		}
+		hook.Env["TFF_KEY"] = strings.ToLower(keyToString(ev.Code))
+		state.runner.Run(&hook)
+	case TrainingDelay:
+		return training.Delay, false
+	case TrainingBlock:
+		if warnings > training.Warnings {
+			fmt.Printf("Training: %s is blocked\n", keyToString(ev.Code))
+			state.trainingBlocked[ev.Code] = true
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// delayTrainingEvent delays the event by delay. While events are delayed, all later key events
+// wait behind them, even if delay is zero. This way keys do not overtake a delayed key. The engine
+// does not wait: timers keep firing. Returns false, if the event does not get delayed.
+func (state *State) delayTrainingEvent(ev *Event, device string, delay time.Duration) bool {
+	if delay == 0 && len(state.trainingDelayed) == 0 {
+		return false
+	}
+	at := syscallTimevalToTime(ev.Time).Add(delay)
+	if n := len(state.trainingDelayed); n > 0 && state.trainingDelayed[n-1].at.After(at) {
+		at = state.trainingDelayed[n-1].at
+	}
+	fmt.Printf("Training: %s gets delayed by %s\n", eventToString(ev, state.config.Layout),
+		at.Sub(syscallTimevalToTime(ev.Time)))
+	state.trainingDelayed = append(state.trainingDelayed, delayedEvent{*ev, device, at})
+	state.trainingDeadline = state.trainingDelayed[0].at
+	return true
+}
+
+// afterTrainingTimer passes the delayed events, which are due at now, to the engine. The events
+// keep the order in which they were read.
+func (state *State) afterTrainingTimer(now time.Time) error {
+	for len(state.trainingDelayed) > 0 {
+		delayed := state.trainingDelayed[0]
+		if delayed.at.After(now) {
+			break
+		}
+		state.trainingDelayed = state.trainingDelayed[1:]
+		ev := delayed.ev
+		ev.Time = timeToSyscallTimeval(delayed.at)
+		// Not "|>>": replaying the log delays the event again.
+		fmt.Printf("\n|->%s (delayed by training)", eventToCsvLine(ev))
+		state.setKeyDevice(&ev, delayed.device)
+		if err := manInTheMiddleInnerLoop(&ev, state.outDev, state); err != nil {
+			return err
+		}
+	}
+	state.trainingDeadline = maxTime
+	if len(state.trainingDelayed) > 0 {
+		state.trainingDeadline = state.trainingDelayed[0].at
+	}
+	return nil
+}
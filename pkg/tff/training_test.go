@@ -0,0 +1,183 @@
+package tff
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Config_trainingKeys(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+combos:
+  - keys: f j
+    outKeys: backspace
+  - keys: d f
+    outKeys: ctrl+c
+  - keys: j k
+    outKeys: f
+`))
+	require.NoError(t, err)
+	// ctrl+c is not a single key. f is part of a combo.
+	trainingKeys := config.trainingKeys()
+	require.Len(t, trainingKeys, 1)
+	require.Equal(t, "KEY_F KEY_J -> KEY_BACKSPACE", trainingKeys[evdev.KEY_BACKSPACE].String())
+}
+
+func Test_Training_Block(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	config, err := LoadYamlFromBytes([]byte(`
+statsFile: ` + path + `
+training:
+  action: block
+  warnings: 2
+combos:
+  - keys: f j
+    outKeys: backspace
+`))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString(
+		"backspace_ (20ms) backspace/ (200ms) backspace_ (20ms) backspace/ (200ms) backspace_ (20ms) backspace= (20ms) backspace/ " +
+			"(200ms) f_ (20ms) j_ (200ms) j/ (20ms) f/")
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	require.NoError(t, manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil))
+
+	// The third press gets blocked. The combo still works.
+	ew.requireEqual(t, `
+		BACKSPACE-down
+		BACKSPACE-up
+		BACKSPACE-down
+		BACKSPACE-up
+		BACKSPACE-down
+		BACKSPACE-up
+		`)
+
+	stats, err := LoadStats(path)
+	require.NoError(t, err)
+	require.Len(t, stats.Training, 1)
+	for _, counts := range stats.Training {
+		require.Equal(t, map[string]int{"BACKSPACE": 3}, counts)
+	}
+	require.Contains(t, stats.Report(), "3  BACKSPACE 3\n")
+}
+
+func Test_Training_Hook(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+training:
+  action: hook
+  run:
+    command: paplay /usr/share/sounds/freedesktop/stereo/bell.oga
+combos:
+  - keys: f j
+    outKeys: esc
+`))
+	require.NoError(t, err)
+	require.Equal(t, DefaultRunTimeout, config.Training.Hook.Timeout)
+	state := NewState(config, &writeToSlice{})
+	runner := &fakeRunner{}
+	state.runner = runner
+	for _, ev := range []Event{
+		{Type: evdev.EV_KEY, Code: evdev.KEY_ESC, Value: DOWN},
+		{Type: evdev.EV_KEY, Code: evdev.KEY_ESC, Value: UP},
+		{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: DOWN},
+	} {
+		require.False(t, state.handleTraining(&ev, ""))
+	}
+	require.Equal(t, []string{"paplay /usr/share/sounds/freedesktop/stereo/bell.oga"}, runner.commands)
+}
+
+func Test_Training_LoadYaml(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte("training:\n  action: delay\ncombos:\n  - keys: f j\n    outKeys: esc\n"))
+	require.NoError(t, err)
+	require.Equal(t, Training{Action: TrainingDelay, Delay: DefaultTrainingDelay}, *config.Training)
+
+	config, err = LoadYamlFromBytes([]byte("training: {}\ncombos:\n  - keys: f j\n    outKeys: esc\n"))
+	require.NoError(t, err)
+	require.Equal(t, TrainingLog, config.Training.Action)
+
+	_, err = LoadYamlFromBytes([]byte("training:\n  action: hook\n"))
+	require.ErrorContains(t, err, `'training' needs 'run', if the action is "hook".`)
+	_, err = LoadYamlFromBytes([]byte("training:\n  action: shout\n"))
+	require.ErrorContains(t, err, `invalid 'action: shout' in 'training'.`)
+	_, err = LoadYamlFromBytes([]byte("training:\n  action: block\n  warnings: -1\n"))
+	require.ErrorContains(t, err, `negative values in 'training' are not allowed.`)
+}
+
+func Test_Training_Delay(t *testing.T) {
+	config, err := LoadYamlFromBytes([]byte(`
+training:
+  action: delay
+  delay: 300ms
+combos:
+  - keys: f j
+    outKeys: backspace
+`))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString(
+		"backspace_ (20ms) x_ (20ms) x/ (20ms) backspace/ (400ms) y_ (20ms) y/")
+	require.NoError(t, err)
+	ew := writeToSlice{}
+	require.NoError(t, manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil))
+
+	// x waits behind backspace. Otherwise backspace would delete it. y is pressed after the
+	// delay, and does not wait.
+	ew.requireEqual(t, `
+		BACKSPACE-down
+		X-down
+		X-up
+		BACKSPACE-up
+		Y-down
+		Y-up
+		`)
+	var times []time.Time
+	for _, ev := range ew.s {
+		if ev.Type == evdev.EV_KEY {
+			times = append(times, syscallTimevalToTime(ev.Time))
+		}
+	}
+	require.Equal(t, times[0], times[1])
+	require.Equal(t, 160*time.Millisecond, times[4].Sub(times[0]))
+}
+
+func Test_Training_Delay_Replay(t *testing.T) {
+	// The log of the engine gets replayed. Delayed events must not be read twice.
+	config, err := LoadYamlFromBytes([]byte(`
+training:
+  action: delay
+  delay: 300ms
+combos:
+  - keys: f j
+    outKeys: backspace
+`))
+	require.NoError(t, err)
+	er, err := NewReadFromSliceInputStateString("backspace_ (20ms) x_ (20ms) x/ (20ms) backspace/")
+	require.NoError(t, err)
+	log, err := os.CreateTemp(t.TempDir(), "tff.log")
+	require.NoError(t, err)
+	defer log.Close()
+	stdout := os.Stdout
+	os.Stdout = log
+	ew := writeToSlice{}
+	err = manInTheMiddle(context.Background(), er, &ew, config, VirtualClock{}, nil)
+	os.Stdout = stdout
+	require.NoError(t, err)
+
+	_, err = log.Seek(0, 0)
+	require.NoError(t, err)
+	replayed := writeToSlice{}
+	logReader := ComboLogEventReader{scanner: bufio.NewScanner(log)}
+	require.NoError(t, manInTheMiddle(context.Background(), &logReader, &replayed, config, VirtualClock{}, nil))
+	require.Equal(t, ew.s, replayed.s)
+	replayed.requireEqual(t, `
+		BACKSPACE-down
+		X-down
+		X-up
+		BACKSPACE-up
+		`)
+}